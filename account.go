@@ -0,0 +1,148 @@
+// account.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "errors"
+    "github.com/vaughan0/go-ini"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/session"
+    "github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+    "github.com/aws/aws-sdk-go/service/iam"
+    "github.com/aws/aws-sdk-go/service/sts"
+)
+
+
+// An AWS account to sweep with -u, as defined in an [account NAME] section of the config file
+type AccountType struct {
+    Name        string            // Section NAME, or 'default' for the current CLI logon
+    Profile     string            // Optional named profile in ~/.aws/config
+    RoleArn     string            // Optional role to assume
+    ExternalId  string            // Optional external ID required by above role
+    RegionConf  string            // Optional regions setting, overriding the global one
+    Regions     []string          // Regions to sweep, set by SetupAccountAccess()
+    Id          string            // Account ID, set by SetupAccountAccess()
+    Alias       string            // Account alias, set by SetupAccountAccess()
+    Sess        *session.Session  // Session with this account's credentials
+    Err         error             // Why this account couldn't be swept, if it couldn't
+}
+
+
+// Return list of accounts defined in given config file, sorted by name
+func GetAccountsFromConfig(cfgfile ini.File) (list []*AccountType) {
+    for name, section := range cfgfile {
+        if !strings.HasPrefix(name, "account ") {
+            continue
+        }
+        acct := &AccountType{
+            Name:       strings.TrimSpace(strings.TrimPrefix(name, "account ")),
+            Profile:    section["profile"],
+            RoleArn:    section["role_arn"],
+            ExternalId: section["external_id"],
+            RegionConf: section["regions"],
+        }
+        list = append(list, acct)
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+    return list
+}
+
+
+// Return list of accounts to sweep, defaulting to the one for the current CLI logon
+func GetAccountList() []*AccountType {
+    if len(AWSAccounts) > 0 {
+        return AWSAccounts
+    }
+    return []*AccountType{&AccountType{Name: "default"}}
+}
+
+
+// Set up the accounts in given list concurrently and return only those that can be swept
+func SetupAccounts(accounts []*AccountType) (list []*AccountType) {
+    SetAWSRegion()
+    RunParallel("sts", len(accounts), func(i int) {
+        accounts[i].Err = SetupAccountAccess(accounts[i])
+    })
+    for _, acct := range accounts {
+        if acct.Err != nil {
+            fmt.Printf("Skipping account %s: %s\n", acct.Name, acct.Err.Error())
+            continue
+        }
+        list = append(list, acct)
+    }
+    return list
+}
+
+
+// Create given account's session, assuming its role if any, and look up its ID and alias
+func SetupAccountAccess(acct *AccountType) error {
+    // Use the region already resolved, which the AssumeRole client needs when the profile has none
+    opts := session.Options{
+        Profile:           acct.Profile,
+        SharedConfigState: session.SharedConfigEnable,
+        Config:            aws.Config{Region: aws.String(AWSRegion)},
+    }
+    sess, err := session.NewSessionWithOptions(opts)
+    if err != nil {
+        return err
+    }
+    if acct.RoleArn != "" {
+        creds := stscreds.NewCredentials(sess, acct.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+            p.RoleSessionName = ProgName
+            if acct.ExternalId != "" {
+                p.ExternalID = aws.String(acct.ExternalId)
+            }
+        })
+        sess = sess.Copy(aws.NewConfig().WithCredentials(creds))
+    }
+    acct.Sess = sess
+
+    // Look up account ID, which also tests whether the credentials work at all
+    svc := sts.New(sess, AWSConfig(AWSRegion))
+    var resp *sts.GetCallerIdentityOutput
+    err = CallWithRetry("sts", func() (err error) {
+        resp, err = svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+        return err
+    })
+    if err != nil {
+        return err
+    }
+    if resp.Account == nil {
+        return errors.New("Unable to determine account ID.")
+    }
+    acct.Id = *resp.Account
+
+    // Look up account alias, falling back to the ID for accounts without one
+    acct.Alias = acct.Id
+    svc2 := iam.New(sess, AWSConfig(AWSRegion))
+    var resp2 *iam.ListAccountAliasesOutput
+    err = CallWithRetry("iam", func() (err error) {
+        resp2, err = svc2.ListAccountAliases(&iam.ListAccountAliasesInput{})
+        return err
+    })
+    if err != nil {
+        return err
+    }
+    if len(resp2.AccountAliases) > 0 && resp2.AccountAliases[0] != nil {
+        acct.Alias = *resp2.AccountAliases[0]
+    }
+
+    acct.Regions = GetAWSRegionList(acct)
+    return nil
+}
+
+
+// Return account with given ID from given list, or nil if it's not in it
+func GetAccountById(accounts []*AccountType, accountId *string) *AccountType {
+    if accountId == nil {
+        return nil
+    }
+    for _, acct := range accounts {
+        if acct.Id == *accountId {
+            return acct
+        }
+    }
+    return nil
+}
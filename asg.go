@@ -0,0 +1,302 @@
+// asg.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "encoding/json"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/autoscaling"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS autoscaling.Group type to include these additional fields
+type AutoScalingGroupType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *autoscaling.Group
+}
+
+// Register this resource kind with the stores
+var ASGKind = RegisterStoreKind("Auto Scaling group", ASGDataFile)
+
+// Return string representation of this type
+func (s AutoScalingGroupType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *AutoScalingGroupType) SetAccountAlias(v string) *AutoScalingGroupType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *AutoScalingGroupType) SetAccountId(v string) *AutoScalingGroupType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *AutoScalingGroupType) SetRegion(v string) *AutoScalingGroupType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all ASG records with applied filter
+func ListASGs(filter string) {
+    asgList, err := GetASGList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    for _, asg := range asgList {
+        name, desired, min, max, instIds, elbs, acctAlias := GetDetailsOfASG(asg)
+        region := "-"
+        if asg.Region != nil { region = *asg.Region }
+        instances := strings.Join(instIds, " ")
+        if filter == "" || strContains(name, filter) || strContains(elbs, filter) ||
+                           strContains(instances, filter) || strContains(acctAlias, filter) ||
+                           strContains(region, filter) {
+            fmt.Printf("%-48s  %4s  %4s  %4s  %4d  %-18s  %-40s  %s\n",
+                name, desired, min, max, len(instIds), acctAlias, elbs, instances)
+        }
+    }
+}
+
+
+// Return ASG records list from the data store
+func GetASGList() (list []AutoScalingGroupType, err error) {
+    err = DataStore.Load(ASGKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object. The ELBs string lists both classic ELB names
+// and target group names
+func GetDetailsOfASG(asg AutoScalingGroupType) (name, desired, min, max string, instIds []string,
+                                                elbs, acctAlias string) {
+    name, desired, min, max, elbs, acctAlias = "-", "-", "-", "-", "-", "-"
+
+    if asg.AutoScalingGroupName != nil { name = *asg.AutoScalingGroupName }
+    if asg.DesiredCapacity != nil { desired = strconv.FormatInt(*asg.DesiredCapacity, 10) }
+    if asg.MinSize != nil { min = strconv.FormatInt(*asg.MinSize, 10) }
+    if asg.MaxSize != nil { max = strconv.FormatInt(*asg.MaxSize, 10) }
+    if asg.AccountAlias != nil { acctAlias = *asg.AccountAlias }
+
+    for _, inst := range asg.Instances {
+        if inst != nil && inst.InstanceId != nil {
+            instIds = append(instIds, *inst.InstanceId)
+        }
+    }
+
+    // Target group ARNs end in 'targetgroup/NAME/ID', so show just the NAME
+    names := aws.StringValueSlice(asg.LoadBalancerNames)
+    for _, arn := range aws.StringValueSlice(asg.TargetGroupARNs) {
+        parts := strings.Split(arn, "/")
+        if len(parts) >= 2 {
+            names = append(names, parts[1])
+        }
+    }
+    if len(names) > 0 {
+        elbs = strings.Join(names, ",")
+    }
+    return
+}
+
+
+// Return name of the ASG given instance belongs to, looking in given ASG list first and then
+// at the instance's own tags, or '-' if it doesn't belong to one
+func GetASGNameOfInstance(inst *InstanceType, asgList []AutoScalingGroupType) string {
+    if inst.InstanceId != nil {
+        if asg := GetASGOfInstanceId(*inst.InstanceId, asgList); asg != nil {
+            return *asg.AutoScalingGroupName
+        }
+    }
+    for _, tag := range inst.Tags {
+        if tag.Key != nil && tag.Value != nil && *tag.Key == "aws:autoscaling:groupName" {
+            return *tag.Value
+        }
+    }
+    return "-"
+}
+
+
+// Return ASG in given list that given instance ID is a member of, or nil if none
+func GetASGOfInstanceId(instId string, asgList []AutoScalingGroupType) *AutoScalingGroupType {
+    for i := range asgList {
+        if asgList[i].Group == nil || asgList[i].AutoScalingGroupName == nil {
+            continue
+        }
+        for _, inst := range asgList[i].Instances {
+            if inst != nil && inst.InstanceId != nil && strings.EqualFold(*inst.InstanceId, instId) {
+                return &asgList[i]
+            }
+        }
+    }
+    return nil
+}
+
+
+// Print given instance IDs grouped under the ASGs they belong to, followed by those that don't
+// belong to any. Health states and the names of the ECS services running on the instances, if
+// any, are appended to each instance line
+func BreakdownInstancesByASG(indent string, instIds []string, health, services map[string]string,
+                             masterInstList []InstanceType, asgList []AutoScalingGroupType) {
+    printInstance := func(indent, instId string) {
+        suffix := ""
+        if state, ok := health[instId]; ok {
+            suffix = "  " + state
+        }
+        if name, ok := services[instId]; ok {
+            suffix += "  ecs:" + name
+        }
+        if line, ok := GetInstanceBreakdownLine(instId, masterInstList); ok {
+            fmt.Printf("%s%s%s\n", indent, line, suffix)
+        } else {
+            fmt.Printf("%s%s not found in instance store%s\n", indent, instId, suffix)
+        }
+    }
+
+    // Group instances by ASG, keeping the order in which they were given
+    var asgs []*AutoScalingGroupType
+    members := map[*AutoScalingGroupType][]string{}
+    var loose []string
+    for _, instId := range instIds {
+        asg := GetASGOfInstanceId(instId, asgList)
+        if asg == nil {
+            loose = append(loose, instId)
+            continue
+        }
+        if _, ok := members[asg]; !ok {
+            asgs = append(asgs, asg)
+        }
+        members[asg] = append(members[asg], instId)
+    }
+
+    for _, asg := range asgs {
+        name, desired, min, max, _, _, _ := GetDetailsOfASG(*asg)
+        fmt.Printf("%sASG %s  (desired %s, min %s, max %s)\n", indent, name, desired, min, max)
+        for _, instId := range members[asg] {
+            printInstance(indent + "  ", instId)
+        }
+    }
+    for _, instId := range loose {
+        printInstance(indent, instId)
+    }
+}
+
+
+// Update local copy of ASG records from given AWS accounts
+func UpdateLocalASGStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update. Instances launched or terminated by the
+    // ASGs themselves show up as EC2 events, so check for those too
+    sweeps := MergeSweepLists(GetSweepList("autoscaling", accounts, minutesAgo),
+                              GetSweepList("ec2", accounts, minutesAgo))
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local ASG store.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip ASG update if no ASG events within last minutesAgo
+            fmt.Printf("Skipping local ASG store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local ASG store (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    results := make([][]AutoScalingGroupType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("autoscaling", len(sweeps), func(i int) {
+        results[i], errs[i] = GetASGListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(ASGKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts and regions
+    var list []AutoScalingGroupType
+    asgList, _ := GetASGList()
+    for _, asg := range asgList {
+        if !RecordInSweep(asg.AccountId, asg.Region, updated) {
+            list = append(list, asg)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, ASGKind)
+    return
+}
+
+
+// Return all ASG objects in given account and region
+func GetASGListFromAWS(acct *AccountType, region string) (list []AutoScalingGroupType, err error) {
+    svc := autoscaling.New(acct.Sess, AWSConfig(region))
+
+    params := &autoscaling.DescribeAutoScalingGroupsInput{
+        MaxRecords: aws.Int64(100),  // 100 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxRecords records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *autoscaling.DescribeAutoScalingGroupsOutput
+        err := CallWithRetry("autoscaling", func() (err error) {
+            resp, err = svc.DescribeAutoScalingGroups(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.AutoScalingGroups != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.AutoScalingGroups)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var asgList []AutoScalingGroupType
+            err = json.Unmarshal(jsonData, &asgList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, asg := range asgList {
+                // Add our additional fields
+                asg = *asg.SetAccountAlias(acct.Alias)
+                asg = *asg.SetAccountId(acct.Id)
+                asg = *asg.SetRegion(region)
+                list = append(list, asg)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
@@ -0,0 +1,96 @@
+// breakdown.go
+package main
+
+import (
+    "fmt"
+    "net"
+    "strings"
+)
+
+
+// A kind of AWS endpoint that a DNS breakdown can recognise, e.g., ELBs or RDS databases
+type DNSTargetType struct {
+    Name       string                         // Short description, used in messages
+    Match      func(dnsName string) bool      // Check if given DNS name is one of these endpoints
+    Breakdown  func(dnsName string) []string  // Print its breakdown, returning any DNS names it
+                                              // forwards to, which get broken down in turn
+}
+
+// All registered DNS targets, in registration order
+var DNSTargets []*DNSTargetType
+
+// Register a new kind of DNS target, so the DNS breakdown recognises it
+func RegisterDNSTarget(name string, match func(string) bool, breakdown func(string) []string) *DNSTargetType {
+    target := &DNSTargetType{Name: name, Match: match, Breakdown: breakdown}
+    DNSTargets = append(DNSTargets, target)
+    return target
+}
+
+
+// Breakdown given DNS name into its backend components, following each hop thru our DNS records
+// and public DNS until it reaches one of the registered DNS targets
+func BreakdownDNS(dnsName string) {
+    BreakdownDNSHops(NormalDNSName(dnsName), map[string]bool{})
+}
+
+
+// Follow given DNS name's hops, skipping any already seen to avoid loops
+func BreakdownDNSHops(hop string, seen map[string]bool) {
+    for !seen[strings.ToLower(hop)] {
+        seen[strings.ToLower(hop)] = true
+
+        // Stop at the first hop that's one of our known endpoints
+        if target := GetDNSTarget(hop); target != nil {
+            for _, next := range target.Breakdown(hop) {
+                BreakdownDNSHops(NormalDNSName(next), seen)
+            }
+            return
+        }
+
+        next, hopType, err := GetNextDNSHop(hop)
+        if err != nil {
+            fmt.Printf("%s  does not resolve: %s\n", hop, err.Error())
+            return   // Only this branch ends, other origins still get followed
+        }
+        if next == "" {
+            return   // End of the line, and it's nothing we know of
+        }
+        fmt.Printf("%s  %s  %s\n", hop, hopType, next)
+        hop = next
+    }
+}
+
+
+// Return registered DNS target matching given DNS name, or nil if none does
+func GetDNSTarget(dnsName string) *DNSTargetType {
+    for _, target := range DNSTargets {
+        if target.Match(dnsName) {
+            return target
+        }
+    }
+    return nil
+}
+
+
+// Return the DNS name given one points to, and the type of record doing the pointing. Our own
+// DNS records are looked at first, so ALIAS records can be followed too. Names that point to
+// nowhere return an error
+func GetNextDNSHop(dnsName string) (next, hopType string, err error) {
+    if dns, err := GetDNSFromLocal(dnsName); err == nil && dns.Type != nil {
+        if *dns.Type == "CNAME" && len(dns.ResourceRecords) > 0 && dns.ResourceRecords[0].Value != nil {
+            return NormalDNSName(*dns.ResourceRecords[0].Value), "CNAME", nil
+        }
+        if dns.AliasTarget != nil && dns.AliasTarget.DNSName != nil {
+            return NormalDNSName(*dns.AliasTarget.DNSName), "ALIAS", nil
+        }
+    }
+    resp, err := net.LookupCNAME(dnsName)
+    if err != nil {
+        return "", "", err
+    }
+    respRec := NormalDNSName(resp)   // Normalize DNS name
+    if strings.EqualFold(respRec, dnsName) {
+        return "", "", nil
+    }
+    return respRec, "CNAME", nil
+}
@@ -0,0 +1,375 @@
+// bucket.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "regexp"
+    "strings"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/s3"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS s3.Bucket type to include these additional fields
+type BucketType struct {
+    AccountAlias       *string
+    AccountId          *string
+    Region             *string                              // Nil if we weren't allowed to read it
+    WebsiteEnabled     *bool                                // Nil if we weren't allowed to read it
+    PublicAccessBlock  *s3.PublicAccessBlockConfiguration   // Nil if bucket has none, or unreadable
+    Tags               map[string]*string
+    DeniedSettings     []*string                            // Settings we weren't allowed to read
+    *s3.Bucket
+}
+
+// Register this resource kind with the stores
+var BucketKind = RegisterStoreKind("S3 bucket", BucketDataFile)
+
+// Register S3 endpoints with the DNS breakdown. They're as far as a breakdown can go
+var BucketTarget = RegisterDNSTarget("S3", IsS3Endpoint, func(dnsName string) []string {
+    BreakdownBucket(dnsName)
+    return nil
+})
+
+// S3 endpoints, e.g., 'b.s3.amazonaws.com', 'b.s3.eu-west-1.amazonaws.com' or
+// 'b.s3-website-us-east-1.amazonaws.com'. The bucket name is missing from path-style endpoints
+var s3EndpointRegexp = regexp.MustCompile(`^(?:(.+)\.)?s3(?:-website)?(?:[.-][a-z0-9-]+)?\.amazonaws\.com$`)
+
+// Bucket location and optional settings, which may be unreadable to us even where the bucket isn't
+const (
+    BucketLocationSetting     = "location"
+    BucketWebsiteSetting      = "website"
+    BucketPublicAccessSetting = "publicaccessblock"
+    BucketTagsSetting         = "tags"
+)
+
+// Return string representation of this type
+func (s BucketType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *BucketType) SetAccountAlias(v string) *BucketType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *BucketType) SetAccountId(v string) *BucketType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *BucketType) SetRegion(v string) *BucketType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all S3 buckets with applied filter
+func ListBuckets(filter string) {
+    list, err := GetBucketList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    for _, bucket := range list {
+        a, b, c, d, e, f := GetDetailsOfBucket(bucket)
+        tags := GetBucketTagString(bucket)
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(f, filter) || strContains(tags, filter) {
+            fmt.Printf("%-63s  %-14s  %-18s  %-16s  %-7s  %-7s  %s\n", a, b, c, d, e, f, tags)
+        }
+    }
+}
+
+
+// Return S3 bucket records list from the data store
+func GetBucketList() (list []BucketType, err error) {
+    err = DataStore.Load(BucketKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object. The public access block is 'blocked' if all
+// its settings are on, 'partial' if only some are, and 'none' if there isn't one
+func GetDetailsOfBucket(bucket BucketType) (name, region, acctAlias, created, website,
+                                            publicBlock string) {
+    name, region, acctAlias, created, website, publicBlock = "-", "-", "-", "-", "-", "none"
+
+    if bucket.Bucket != nil && bucket.Name != nil { name = *bucket.Name }
+    if bucket.Region != nil { region = *bucket.Region }
+    if bucket.IsSettingDenied(BucketLocationSetting) { region = "unknown" }
+    if bucket.AccountAlias != nil { acctAlias = *bucket.AccountAlias }
+    if bucket.Bucket != nil && bucket.CreationDate != nil {
+        created = bucket.CreationDate.Format("2006-01-02 15:04")
+    }
+    if bucket.WebsiteEnabled != nil && *bucket.WebsiteEnabled { website = "website" }
+    if bucket.IsSettingDenied(BucketWebsiteSetting) { website = "unknown" }
+    if bucket.IsSettingDenied(BucketPublicAccessSetting) { publicBlock = "unknown" }
+    if pab := bucket.PublicAccessBlock; pab != nil {
+        on := 0
+        for _, v := range []*bool{pab.BlockPublicAcls, pab.BlockPublicPolicy,
+                                  pab.IgnorePublicAcls, pab.RestrictPublicBuckets} {
+            if aws.BoolValue(v) { on++ }
+        }
+        if on == 4 {
+            publicBlock = "blocked"
+        } else if on > 0 {
+            publicBlock = "partial"
+        }
+    }
+    return
+}
+
+
+// Return given bucket's tags as a sorted, space-separated string of KEY=VALUE pairs
+func GetBucketTagString(bucket BucketType) string {
+    var tags []string
+    for k, v := range bucket.Tags {
+        tags = append(tags, k + "=" + aws.StringValue(v))
+    }
+    sort.Strings(tags)
+    return strings.Join(tags, " ")
+}
+
+
+// Return bucket with given name from given list, or nil if it's not in it
+func GetBucketByName(name string, list []BucketType) *BucketType {
+    for i := range list {
+        if list[i].Bucket != nil && aws.StringValue(list[i].Name) == name {
+            return &list[i]
+        }
+    }
+    return nil
+}
+
+
+// Check if given DNS name is an S3 bucket or website endpoint
+func IsS3Endpoint(dnsName string) bool {
+    return s3EndpointRegexp.MatchString(strings.ToLower(NormalDNSName(dnsName)))
+}
+
+
+// Print the bucket behind given S3 endpoint
+func BreakdownBucket(dnsName string) {
+    fmt.Println(dnsName)
+    m := s3EndpointRegexp.FindStringSubmatch(strings.ToLower(NormalDNSName(dnsName)))
+    if m == nil || m[1] == "" {
+        fmt.Println("  S3 endpoint, with no bucket name in it")
+        return
+    }
+    list, _ := GetBucketList()
+    bucket := GetBucketByName(m[1], list)
+    if bucket == nil {
+        fmt.Printf("  bucket  %s not found in S3 store\n", m[1])
+        return
+    }
+    a, b, c, d, e, f := GetDetailsOfBucket(*bucket)
+    fmt.Printf("  bucket  %-63s  %-14s  %-18s  %-16s  %-7s  %s\n", a, b, c, d, e, f)
+}
+
+
+// Update local S3 bucket store from given AWS accounts
+func UpdateLocalBucketStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts to update. Buckets are all listed at once per account, so any
+    // region with S3 events means the whole account gets updated
+    var sweeps []SweepType
+    for _, sweep := range GetSweepList("s3", accounts, minutesAgo) {
+        found := false
+        for _, s := range sweeps {
+            if s.Account == sweep.Account {
+                found = true
+                break
+            }
+        }
+        if !found {
+            sweeps = append(sweeps, SweepType{Account: sweep.Account, Region: GlobalEventsRegion})
+        }
+    }
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local S3 bucket store.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no S3 events within last minutesAgo
+            fmt.Printf("Skipping local S3 bucket store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local S3 bucket store (%d accounts modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account concurrently
+    results := make([][]BucketType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("s3", len(sweeps), func(i int) {
+        results[i], errs[i] = GetBucketListFromAWS(sweeps[i].Account)
+    })
+
+    // Keep previous records for the accounts that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(BucketKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts, in
+    // whatever region their buckets are
+    var list []BucketType
+    bucketList, _ := GetBucketList()
+    for _, bucket := range bucketList {
+        if !RecordInSweep(bucket.AccountId, nil, updated) {
+            list = append(list, bucket)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, BucketKind)
+    return
+}
+
+
+// Return all S3 bucket objects in given account, with their region, website, public access
+// block and tags settings
+func GetBucketListFromAWS(acct *AccountType) (list []BucketType, err error) {
+    svc := s3.New(acct.Sess, AWSConfig(GlobalEventsRegion))
+
+    // There's no paging, all buckets come back at once
+    var resp *s3.ListBucketsOutput
+    err = CallWithRetry("s3", func() (err error) {
+        resp, err = svc.ListBuckets(&s3.ListBucketsInput{})
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    // Bucket settings have to be read from the bucket's own region
+    regionSvcs := map[string]*s3.S3{}
+    for _, b := range resp.Buckets {
+        if b == nil || b.Name == nil {
+            continue
+        }
+        var resp2 *s3.GetBucketLocationOutput
+        err := CallWithRetry("s3", func() (err error) {
+            resp2, err = svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: b.Name})
+            return err
+        })
+        if IsErrorCode(err, s3.ErrCodeNoSuchBucket) {
+            continue   // Deleted since it was listed
+        } else if IsErrorCode(err, "AccessDenied") {
+            // Without its region, none of the bucket's settings can be read either
+            bucket := BucketType{Bucket: b}
+            bucket.DeniedSettings = aws.StringSlice([]string{BucketLocationSetting,
+                BucketWebsiteSetting, BucketPublicAccessSetting, BucketTagsSetting})
+            bucket = *bucket.SetAccountAlias(acct.Alias)
+            bucket = *bucket.SetAccountId(acct.Id)
+            list = append(list, bucket)
+            continue
+        } else if err != nil {
+            return nil, err
+        }
+        region := GetBucketRegion(resp2.LocationConstraint)
+        if regionSvcs[region] == nil {
+            regionSvcs[region] = s3.New(acct.Sess, AWSConfig(region))
+        }
+        bucket, err := GetBucketSettingsFromAWS(regionSvcs[region], b)
+        if IsErrorCode(err, s3.ErrCodeNoSuchBucket) {
+            continue   // Deleted since its location was read
+        } else if err != nil {
+            return nil, err
+        }
+        // Add our additional fields
+        bucket = *bucket.SetAccountAlias(acct.Alias)
+        bucket = *bucket.SetAccountId(acct.Id)
+        bucket = *bucket.SetRegion(region)
+        list = append(list, bucket)
+    }
+    return list, nil
+}
+
+
+// Return region of given bucket location constraint, which is empty for us-east-1 and 'EU'
+// for the oldest eu-west-1 buckets
+func GetBucketRegion(location *string) string {
+    switch aws.StringValue(location) {
+    case "":
+        return "us-east-1"
+    case s3.BucketLocationConstraintEu:
+        return "eu-west-1"
+    }
+    return *location
+}
+
+
+// Return given bucket with its website, public access block and tags settings. Settings
+// the bucket doesn't have come back as errors, which just leave them unset. A bucket deleted
+// meanwhile returns a NoSuchBucket error
+func GetBucketSettingsFromAWS(svc *s3.S3, b *s3.Bucket) (bucket BucketType, err error) {
+    bucket = BucketType{Bucket: b}
+
+    // Settings we're denied access to are left unknown, rather than failing the whole sweep
+    err = CallWithRetry("s3", func() (err error) {
+        _, err = svc.GetBucketWebsite(&s3.GetBucketWebsiteInput{Bucket: b.Name})
+        return err
+    })
+    if IsErrorCode(err, "AccessDenied") {
+        bucket.DeniedSettings = append(bucket.DeniedSettings, aws.String(BucketWebsiteSetting))
+    } else if err != nil && !IsErrorCode(err, "NoSuchWebsiteConfiguration") {
+        return bucket, err
+    } else {
+        bucket.WebsiteEnabled = aws.Bool(err == nil)
+    }
+
+    var pab *s3.GetPublicAccessBlockOutput
+    err = CallWithRetry("s3", func() (err error) {
+        pab, err = svc.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: b.Name})
+        return err
+    })
+    if err == nil {
+        bucket.PublicAccessBlock = pab.PublicAccessBlockConfiguration
+    } else if IsErrorCode(err, "AccessDenied") {
+        bucket.DeniedSettings = append(bucket.DeniedSettings, aws.String(BucketPublicAccessSetting))
+    } else if !IsErrorCode(err, "NoSuchPublicAccessBlockConfiguration") {
+        return bucket, err
+    }
+
+    var tagging *s3.GetBucketTaggingOutput
+    err = CallWithRetry("s3", func() (err error) {
+        tagging, err = svc.GetBucketTagging(&s3.GetBucketTaggingInput{Bucket: b.Name})
+        return err
+    })
+    if err == nil {
+        bucket.Tags = map[string]*string{}
+        for _, tag := range tagging.TagSet {
+            if tag != nil && tag.Key != nil {
+                bucket.Tags[*tag.Key] = tag.Value
+            }
+        }
+    } else if IsErrorCode(err, "AccessDenied") {
+        bucket.DeniedSettings = append(bucket.DeniedSettings, aws.String(BucketTagsSetting))
+    } else if !IsErrorCode(err, "NoSuchTagSet") {
+        return bucket, err
+    }
+    return bucket, nil
+}
+
+
+// Check if we weren't allowed to read given setting of this bucket
+func (s *BucketType) IsSettingDenied(setting string) bool {
+    return strInList(setting, aws.StringValueSlice(s.DeniedSettings))
+}
@@ -0,0 +1,374 @@
+// cert.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "time"
+    "strings"
+    "strconv"
+    "crypto/x509"
+    "encoding/pem"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/acm"
+    "github.com/aws/aws-sdk-go/service/iam"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS acm.CertificateDetail type to include these additional fields. IAM server certs
+// are kept in the same type, with Type set to 'IAM'
+type CertificateType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *acm.CertificateDetail
+}
+
+// Register this resource kind with the stores
+var CertKind = RegisterStoreKind("Certificate", CertDataFile)
+
+// All ACM key types, since ListCertificates only returns RSA_2048 ones by default
+var acmKeyTypes = []string{
+    "RSA_1024", "RSA_2048", "RSA_3072", "RSA_4096", "EC_prime256v1", "EC_secp384r1", "EC_secp521r1",
+}
+
+// Return string representation of this type
+func (s CertificateType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *CertificateType) SetAccountAlias(v string) *CertificateType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *CertificateType) SetAccountId(v string) *CertificateType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *CertificateType) SetRegion(v string) *CertificateType {
+    s.Region = &v
+    return s
+}
+
+
+// Return certificate records list from the data store
+func GetCertList() (list []CertificateType, err error) {
+    err = DataStore.Load(CertKind, &list)
+    return list, err
+}
+
+
+// Return certificate with given ARN from given list, or nil if it's not in it
+func GetCertByArn(arn string, certList []CertificateType) *CertificateType {
+    for i := range certList {
+        if certList[i].CertificateDetail != nil && aws.StringValue(certList[i].CertificateArn) == arn {
+            return &certList[i]
+        }
+    }
+    return nil
+}
+
+
+// Return expiry date, days left and domains of cert with given ARN in given list, or '-' for
+// any that can't be worked out
+func GetCertExpiryDetails(arn string, certList []CertificateType) (expiry, daysLeft, domains string) {
+    expiry, daysLeft, domains = "-", "-", "-"
+    cert := GetCertByArn(arn, certList)
+    if cert == nil {
+        return
+    }
+    if cert.NotAfter != nil {
+        expiry = cert.NotAfter.Format("2006-01-02")
+        daysLeft = strconv.Itoa(GetCertDaysLeft(cert))
+    }
+    names := aws.StringValueSlice(cert.SubjectAlternativeNames)
+    if cert.DomainName != nil && !strInList(*cert.DomainName, names) {
+        names = append([]string{*cert.DomainName}, names...)
+    }
+    if len(names) > 0 {
+        domains = strings.Join(names, ",")
+    }
+    return
+}
+
+
+// Return number of days left before given cert expires, negative if it already has
+func GetCertDaysLeft(cert *CertificateType) int {
+    if cert.NotAfter == nil {
+        return 0
+    }
+    return int(time.Until(*cert.NotAfter).Hours() / 24)
+}
+
+
+// Display all certs expiring within given number of days, including those already expired,
+// each followed by the ELB listeners using it
+func ListExpiringCerts(daysStr string) {
+    days, err := strconv.Atoi(daysStr)
+    if err != nil || days < 0 {
+        Die(1, "Error. DAYS (" + daysStr + ") must be a positive number.")
+    }
+    certList, err := GetCertList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    elbList, _ := GetELBList()
+    elbV2List, _ := GetELBV2List()
+
+    // Soonest to expire first
+    var expiring []CertificateType
+    for _, cert := range certList {
+        if cert.CertificateDetail != nil && cert.NotAfter != nil && GetCertDaysLeft(&cert) <= days {
+            expiring = append(expiring, cert)
+        }
+    }
+    sort.Slice(expiring, func(i, j int) bool { return expiring[i].NotAfter.Before(*expiring[j].NotAfter) })
+
+    for _, cert := range expiring {
+        arn := aws.StringValue(cert.CertificateArn)
+        expiry, daysLeft, domains := GetCertExpiryDetails(arn, certList)
+        certType, acctAlias := "-", "-"
+        if cert.Type != nil { certType = *cert.Type }
+        if cert.AccountAlias != nil { acctAlias = *cert.AccountAlias }
+        fmt.Printf("%-10s  %5s  %-14s  %-18s  %-50s  %s\n", expiry, daysLeft, certType, acctAlias, domains, arn)
+
+        // Listeners using it
+        for _, elb := range elbList {
+            for _, l := range elb.ListenerDescriptions {
+                if l != nil && l.Listener != nil && aws.StringValue(l.Listener.SSLCertificateId) == arn {
+                    fmt.Printf("  %-80s  %s:%d\n", aws.StringValue(elb.DNSName),
+                        aws.StringValue(l.Listener.Protocol), aws.Int64Value(l.Listener.LoadBalancerPort))
+                }
+            }
+        }
+        for _, elb := range elbV2List {
+            for _, l := range elb.Listeners {
+                if l == nil || l.Listener == nil {
+                    continue
+                }
+                for _, c := range l.Certificates {
+                    if c != nil && aws.StringValue(c.CertificateArn) == arn {
+                        fmt.Printf("  %-80s  %s:%d\n", aws.StringValue(elb.DNSName),
+                            aws.StringValue(l.Protocol), aws.Int64Value(l.Port))
+                    }
+                }
+            }
+        }
+    }
+}
+
+
+// Update local certificate store from given AWS accounts
+func UpdateLocalCertStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update. IAM server certs are global, so they're
+    // swept once per account
+    sweeps := GetSweepList("acm", accounts, minutesAgo)
+    iamSweeps := GetGlobalSweepList("iam", accounts, minutesAgo, GlobalRegion)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local certificate store.\n")
+    } else {
+        if len(sweeps) + len(iamSweeps) < 1 {
+            // Skip update if no ACM or IAM events within last minutesAgo
+            fmt.Printf("Skipping local certificate store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local certificate store (%d regions modified within %d minutes)\n",
+            len(sweeps) + len(iamSweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    results := make([][]CertificateType, len(sweeps) + len(iamSweeps))
+    errs := make([]error, len(sweeps) + len(iamSweeps))
+    RunParallel("acm", len(sweeps), func(i int) {
+        results[i], errs[i] = GetACMCertListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+    RunParallel("iam", len(iamSweeps), func(i int) {
+        n := len(sweeps) + i
+        results[n], errs[n] = GetIAMCertListFromAWS(iamSweeps[i].Account)
+    })
+    sweeps = append(sweeps, iamSweeps...)
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(CertKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts and regions
+    var list []CertificateType
+    certList, _ := GetCertList()
+    for _, cert := range certList {
+        if !RecordInSweep(cert.AccountId, cert.Region, updated) {
+            list = append(list, cert)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, CertKind)
+    return
+}
+
+
+// Return all ACM cert objects in given account and region
+func GetACMCertListFromAWS(acct *AccountType, region string) (list []CertificateType, err error) {
+    svc := acm.New(acct.Sess, AWSConfig(region))
+
+    params := &acm.ListCertificatesInput{
+        Includes: &acm.Filters{KeyTypes: aws.StringSlice(acmKeyTypes)},
+        MaxItems: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxItems records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *acm.ListCertificatesOutput
+        err := CallWithRetry("acm", func() (err error) {
+            resp, err = svc.ListCertificates(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // The list only has ARNs and domains, so get the details of each one
+        for _, summary := range resp.CertificateSummaryList {
+            if summary == nil || summary.CertificateArn == nil {
+                continue
+            }
+            var resp2 *acm.DescribeCertificateOutput
+            err := CallWithRetry("acm", func() (err error) {
+                resp2, err = svc.DescribeCertificate(&acm.DescribeCertificateInput{
+                    CertificateArn: summary.CertificateArn,
+                })
+                return err
+            })
+            if err != nil {
+                return nil, err
+            }
+            if resp2.Certificate == nil {
+                continue
+            }
+            cert := CertificateType{CertificateDetail: resp2.Certificate}
+            // Add our additional fields
+            cert = *cert.SetAccountAlias(acct.Alias)
+            cert = *cert.SetAccountId(acct.Id)
+            cert = *cert.SetRegion(region)
+            list = append(list, cert)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
+
+
+// Return all IAM server cert objects in given account, converted to ACM cert details
+func GetIAMCertListFromAWS(acct *AccountType) (list []CertificateType, err error) {
+    svc := iam.New(acct.Sess, AWSConfig(AWSRegion))
+
+    params := &iam.ListServerCertificatesInput{
+        MaxItems: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxItems records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *iam.ListServerCertificatesOutput
+        err := CallWithRetry("iam", func() (err error) {
+            resp, err = svc.ListServerCertificates(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // The list only has metadata, so get the cert body of each one for its domains
+        for _, meta := range resp.ServerCertificateMetadataList {
+            if meta == nil || meta.ServerCertificateName == nil {
+                continue
+            }
+            var resp2 *iam.GetServerCertificateOutput
+            err := CallWithRetry("iam", func() (err error) {
+                resp2, err = svc.GetServerCertificate(&iam.GetServerCertificateInput{
+                    ServerCertificateName: meta.ServerCertificateName,
+                })
+                return err
+            })
+            if err != nil {
+                return nil, err
+            }
+            cert := CertificateType{CertificateDetail: GetIAMCertDetail(meta, resp2.ServerCertificate)}
+            // Add our additional fields
+            cert = *cert.SetAccountAlias(acct.Alias)
+            cert = *cert.SetAccountId(acct.Id)
+            cert = *cert.SetRegion(GlobalRegion)
+            list = append(list, cert)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.IsTruncated == nil || !*resp.IsTruncated {
+            break
+        } else {
+            params.Marker = resp.Marker
+        }
+    }
+    return list, nil
+}
+
+
+// Return ACM cert detail for given IAM server cert, with its domains taken from the cert body
+func GetIAMCertDetail(meta *iam.ServerCertificateMetadata, sc *iam.ServerCertificate) *acm.CertificateDetail {
+    detail := &acm.CertificateDetail{
+        CertificateArn: meta.Arn,
+        DomainName:     meta.ServerCertificateName,
+        ImportedAt:     meta.UploadDate,
+        NotAfter:       meta.Expiration,
+        Status:         aws.String("ISSUED"),
+        Type:           aws.String("IAM"),
+    }
+    if meta.Expiration != nil && meta.Expiration.Before(time.Now()) {
+        detail.Status = aws.String("EXPIRED")
+    }
+    if sc == nil || sc.CertificateBody == nil {
+        return detail
+    }
+    block, _ := pem.Decode([]byte(*sc.CertificateBody))
+    if block == nil {
+        return detail
+    }
+    x509Cert, err := x509.ParseCertificate(block.Bytes)
+    if err != nil {
+        return detail   // Keep what the metadata has
+    }
+    if x509Cert.Subject.CommonName != "" {
+        detail.DomainName = aws.String(x509Cert.Subject.CommonName)
+    }
+    detail.SubjectAlternativeNames = aws.StringSlice(x509Cert.DNSNames)
+    detail.NotBefore = aws.Time(x509Cert.NotBefore)
+    detail.NotAfter = aws.Time(x509Cert.NotAfter)
+    detail.Issuer = aws.String(x509Cert.Issuer.CommonName)
+    detail.Subject = aws.String(x509Cert.Subject.String())
+    detail.Serial = aws.String(x509Cert.SerialNumber.String())
+    return detail
+}
@@ -0,0 +1,317 @@
+// cloudfront.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/cloudfront"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS cloudfront.DistributionSummary type to include these additional fields
+type DistributionType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *cloudfront.DistributionSummary
+}
+
+// Register this resource kind with the stores
+var CloudFrontKind = RegisterStoreKind("CloudFront distribution", CloudFrontDataFile)
+
+// Register CloudFront endpoints with the DNS breakdown. They carry on into their origins
+var CloudFrontTarget = RegisterDNSTarget("CloudFront", IsCloudFrontEndpoint, BreakdownCloudFront)
+
+// Return string representation of this type
+func (s DistributionType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *DistributionType) SetAccountAlias(v string) *DistributionType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *DistributionType) SetAccountId(v string) *DistributionType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *DistributionType) SetRegion(v string) *DistributionType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all CloudFront distributions with applied filter. With option -fv also display
+// their certificate, origins and cache behaviors
+func ListDistributions(filter string, option string) {
+    list, err := GetDistributionList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    for _, dist := range list {
+        a, b, c, d, e, f, g := GetDetailsOfDistribution(dist)
+        origins := GetDistributionOriginDomains(dist)
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(f, filter) || strContains(g, filter) ||
+           strContains(strings.Join(origins, " "), filter) {
+            fmt.Printf("%-14s  %-40s  %-8s  %-10s  %-18s  %s\n", a, b, c, d, e, f)
+            if option == "-fv" {
+                PrintDistributionDetails(dist, "  ")
+            }
+        }
+    }
+}
+
+
+// Print given distribution's certificate, and its origins with the cache behaviors using them
+func PrintDistributionDetails(dist DistributionType, indent string) {
+    _, _, _, _, _, _, cert := GetDetailsOfDistribution(dist)
+    if strings.HasPrefix(cert, "arn:") {
+        certList, _ := GetCertList()
+        expiry, daysLeft, _ := GetCertExpiryDetails(cert, certList)
+        fmt.Printf("%scert    %s  %s  %s\n", indent, cert, expiry, daysLeft)
+    } else {
+        fmt.Printf("%scert    %s\n", indent, cert)
+    }
+
+    if dist.Origins == nil || len(dist.Origins.Items) == 0 {
+        fmt.Printf("%sNo origins defined\n", indent)
+        return
+    }
+    behaviors := GetDistributionBehaviors(dist)
+    for _, origin := range dist.Origins.Items {
+        if origin == nil {
+            continue
+        }
+        id, originType, domain := GetDetailsOfOrigin(origin)
+        fmt.Printf("%sorigin  %-32s  %-6s  %-64s  %s\n", indent, id, originType, domain,
+            strings.Join(behaviors[id], " "))
+    }
+}
+
+
+// Return CloudFront distribution records list from the data store
+func GetDistributionList() (list []DistributionType, err error) {
+    err = DataStore.Load(CloudFrontKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfDistribution(dist DistributionType) (id, domain, enabled, status, acctAlias,
+                                                      aliases, cert string) {
+    id, domain, enabled, status, acctAlias, aliases, cert = "-", "-", "-", "-", "-", "-", "-"
+
+    if dist.DistributionSummary == nil {
+        return
+    }
+    if dist.Id != nil { id = *dist.Id }
+    if dist.DomainName != nil { domain = *dist.DomainName }
+    if dist.Enabled != nil {
+        enabled = "disabled"
+        if *dist.Enabled { enabled = "enabled" }
+    }
+    if dist.Status != nil { status = *dist.Status }
+    if dist.AccountAlias != nil { acctAlias = *dist.AccountAlias }
+    if dist.Aliases != nil && len(dist.Aliases.Items) > 0 {
+        aliases = strings.Join(aws.StringValueSlice(dist.Aliases.Items), ",")
+    }
+    if vc := dist.ViewerCertificate; vc != nil {
+        if vc.ACMCertificateArn != nil {
+            cert = *vc.ACMCertificateArn
+        } else if vc.IAMCertificateId != nil {
+            cert = "iam:" + *vc.IAMCertificateId
+        } else if vc.CloudFrontDefaultCertificate != nil && *vc.CloudFrontDefaultCertificate {
+            cert = "default"
+        }
+    }
+    return
+}
+
+
+// Return important attributes of given origin. Its type is either 'S3' or 'custom'
+func GetDetailsOfOrigin(origin *cloudfront.Origin) (id, originType, domain string) {
+    id, originType, domain = "-", "custom", "-"
+
+    if origin.Id != nil { id = *origin.Id }
+    if origin.S3OriginConfig != nil { originType = "S3" }
+    if origin.DomainName != nil { domain = *origin.DomainName + aws.StringValue(origin.OriginPath) }
+    return
+}
+
+
+// Return path patterns of given distribution's cache behaviors, keyed by the origin they use.
+// The default cache behavior is shown as '*'
+func GetDistributionBehaviors(dist DistributionType) map[string][]string {
+    behaviors := map[string][]string{}
+    if dist.CacheBehaviors != nil {
+        for _, cb := range dist.CacheBehaviors.Items {
+            if cb != nil && cb.TargetOriginId != nil && cb.PathPattern != nil {
+                behaviors[*cb.TargetOriginId] = append(behaviors[*cb.TargetOriginId], *cb.PathPattern)
+            }
+        }
+    }
+    if cb := dist.DefaultCacheBehavior; cb != nil && cb.TargetOriginId != nil {
+        behaviors[*cb.TargetOriginId] = append(behaviors[*cb.TargetOriginId], "*")
+    }
+    return behaviors
+}
+
+
+// Return domain names of given distribution's origins, without duplicates
+func GetDistributionOriginDomains(dist DistributionType) (domains []string) {
+    if dist.DistributionSummary == nil || dist.Origins == nil {
+        return nil
+    }
+    for _, origin := range dist.Origins.Items {
+        if origin != nil && origin.DomainName != nil && !strInList(*origin.DomainName, domains) {
+            domains = append(domains, *origin.DomainName)
+        }
+    }
+    return domains
+}
+
+
+// Return distribution with given CloudFront domain name from given list, or nil if it's not in it
+func GetDistributionByDomain(dnsName string, list []DistributionType) *DistributionType {
+    for i := range list {
+        if list[i].DistributionSummary != nil &&
+           strings.EqualFold(aws.StringValue(list[i].DomainName), NormalDNSName(dnsName)) {
+            return &list[i]
+        }
+    }
+    return nil
+}
+
+
+// Check if given DNS name is a CloudFront endpoint
+func IsCloudFrontEndpoint(dnsName string) bool {
+    return strings.HasSuffix(strings.ToLower(NormalDNSName(dnsName)), ".cloudfront.net")
+}
+
+
+// Breakdown given CloudFront endpoint into its distribution, returning its origins' domain
+// names so the DNS breakdown can carry on into them
+func BreakdownCloudFront(dnsName string) []string {
+    fmt.Println(dnsName)
+    list, _ := GetDistributionList()
+    dist := GetDistributionByDomain(dnsName, list)
+    if dist == nil {
+        fmt.Printf("  %s not found in CloudFront store\n", dnsName)
+        return nil
+    }
+    a, _, c, d, e, f, _ := GetDetailsOfDistribution(*dist)
+    // a = Id    c = Enabled    d = Status    e = AccountAlias    f = Aliases
+    fmt.Printf("  distribution  %-14s  %-8s  %-10s  %-18s  %s\n", a, c, d, e, f)
+    PrintDistributionDetails(*dist, "  ")
+    return GetDistributionOriginDomains(*dist)
+}
+
+
+// Update local CloudFront distribution store from given AWS accounts
+func UpdateLocalCloudFrontStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts to update. CloudFront is global, so there's one sweep per account
+    sweeps := GetGlobalSweepList("cloudfront", accounts, minutesAgo, GlobalRegion)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local CloudFront store.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no CloudFront events within last minutesAgo
+            fmt.Printf("Skipping local CloudFront store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local CloudFront store (%d accounts modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account concurrently
+    results := make([][]DistributionType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("cloudfront", len(sweeps), func(i int) {
+        results[i], errs[i] = GetDistributionListFromAWS(sweeps[i].Account)
+    })
+
+    // Keep previous records for the accounts that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(CloudFrontKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts
+    var list []DistributionType
+    distList, _ := GetDistributionList()
+    for _, dist := range distList {
+        if !RecordInSweep(dist.AccountId, dist.Region, updated) {
+            list = append(list, dist)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, CloudFrontKind)
+    return
+}
+
+
+// Return all CloudFront distribution objects in given account
+func GetDistributionListFromAWS(acct *AccountType) (list []DistributionType, err error) {
+    svc := cloudfront.New(acct.Sess, AWSConfig(GlobalEventsRegion))
+
+    params := &cloudfront.ListDistributionsInput{
+        MaxItems: aws.Int64(100),  // 100 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxItems records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *cloudfront.ListDistributionsOutput
+        err := CallWithRetry("cloudfront", func() (err error) {
+            resp, err = svc.ListDistributions(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        if resp.DistributionList == nil {
+            break
+        }
+
+        for _, summary := range resp.DistributionList.Items {
+            if summary == nil || summary.Id == nil {
+                continue
+            }
+            dist := DistributionType{DistributionSummary: summary}
+            // Add our additional fields
+            dist = *dist.SetAccountAlias(acct.Alias)
+            dist = *dist.SetAccountId(acct.Id)
+            dist = *dist.SetRegion(GlobalRegion)
+            list = append(list, dist)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.DistributionList.IsTruncated == nil || !*resp.DistributionList.IsTruncated {
+            break
+        } else {
+            params.Marker = resp.DistributionList.NextMarker
+        }
+    }
+    return list, nil
+}
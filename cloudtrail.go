@@ -2,20 +2,17 @@
 package main
 
 import (
-    "fmt"
     "time"
     "strings"
     "github.com/aws/aws-sdk-go/aws"
-    "github.com/aws/aws-sdk-go/aws/session"
     "github.com/aws/aws-sdk-go/service/cloudtrail"
 )
 
 
-// Get all CloudTrail events for given AWS source, within last minutes_ago or 7 days ago
-func GetCloudTrailEvents(source string, minutesAgo int) (list []*cloudtrail.Event) {
-    SetAWSRegion()
-    sess := session.Must(session.NewSession())
-    svc := cloudtrail.New(sess, aws.NewConfig().WithRegion(AWSRegion))
+// Get all CloudTrail events for given AWS source in given account and region, within last
+// minutes_ago or 7 days ago
+func GetCloudTrailEvents(acct *AccountType, source, region string, minutesAgo int) (list []*cloudtrail.Event, err error) {
+    svc := cloudtrail.New(acct.Sess, AWSConfig(region))
     source = source + ".amazonaws.com"
 
     startTime := time.Now().UTC()
@@ -40,24 +37,16 @@ func GetCloudTrailEvents(source string, minutesAgo int) (list []*cloudtrail.Even
     // UNCOMMENT TO HELP DEBUGGING
     //fmt.Printf("Checking CloudTrail for updates in source: %s\n", source)
 
-    // Loop requests, in case there are more than maxResults records or AWS is throttling
-    errcount := 0
+    // Loop requests, in case there are more than maxResults records
     for {
-        // Get batch of records
-	    resp, err := svc.LookupEvents(params)
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *cloudtrail.LookupEventsOutput
+        err := CallWithRetry("cloudtrail", func() (err error) {
+            resp, err = svc.LookupEvents(params)
+            return err
+        })
         if err != nil {
-            // Sleep for a moment if AWS is throttling us
-            if BeingThrottled(err) {
-                fmt.Printf("AWS throttling. Sleeping %d seconds...\n", APISecondsDelay)
-                time.Sleep(time.Duration(APISecondsDelay) * time.Second)
-                continue
-            }
-            // Allow for 3 other unknown API call errors before panicking
-            if errcount < 3 {
-                errcount++
-                continue
-            }
-            panic(err.Error())   // Abort on any other error
+            return nil, err
         }
 
         for _, event := range resp.Events {  // Add this batch to the list
@@ -79,5 +68,5 @@ func GetCloudTrailEvents(source string, minutesAgo int) (list []*cloudtrail.Even
         }
 
     }
-    return list
+    return list, nil
 }
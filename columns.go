@@ -0,0 +1,314 @@
+// columns.go
+package main
+
+import (
+    "fmt"
+    "time"
+    "bytes"
+    "strings"
+    "reflect"
+    "text/template"
+    "encoding/json"
+)
+
+// Columns given with --columns, empty meaning each listing's own. Besides a listing's fields,
+// a column can be 'tag:KEY' for a tag of its records, or a field of the records themselves,
+// e.g. 'Placement.AvailabilityZone' for instances
+var OutputColumns []string
+
+// Template given with --template, executed for each row of a listing
+var OutputTemplate *template.Template
+
+// Column presets defined in the [columns] section of the config file, e.g. 'mine = name,tag:Team'
+var ColumnPresets = map[string]string{}
+
+// Functions available to templates, on top of the listing's fields, e.g. '{{tag .Record "Team"}}'
+var templateFuncs = template.FuncMap{
+    "tag":   GetRecordTag,
+    "field": GetRecordField,
+}
+
+
+// Set output columns to given comma-separated list, or to the ones of given preset
+func SetOutputColumns(spec string) {
+    if preset, ok := ColumnPresets[spec]; ok {
+        spec = preset
+    }
+    OutputColumns = nil
+    for _, col := range strings.Split(spec, ",") {
+        if col = strings.TrimSpace(col); col != "" {
+            OutputColumns = append(OutputColumns, col)
+        }
+    }
+    if len(OutputColumns) == 0 {
+        Die(1, "Error. No columns in '" + spec + "'")
+    }
+}
+
+
+// Set output template to given one, aborting if it doesn't parse
+func SetOutputTemplate(text string) {
+    tmpl, err := template.New("row").Funcs(templateFuncs).Parse(text)
+    if err != nil {
+        Die(1, "Error. Invalid template: " + err.Error())
+    }
+    OutputTemplate = tmpl
+}
+
+
+// Replace the fields of each row of this listing with the selected output columns
+func (l *ListingType) SelectColumns() {
+    values := l.GetColumnValues(OutputColumns)
+    for i, row := range l.Rows {
+        row.Fields = NewFieldList(OutputColumns, values[i]...)
+    }
+    l.Fields = OutputColumns
+}
+
+
+// Return values of given columns for each row of this listing, aborting if any column is
+// neither a field, a tag, nor a field of any of the records
+func (l *ListingType) GetColumnValues(cols []string) [][]string {
+    values := make([][]string, len(l.Rows))
+    found := make([]bool, len(cols))
+    for i, row := range l.Rows {
+        values[i] = make([]string, len(cols))
+        for j, col := range cols {
+            value, ok := GetRowColumn(row, col)
+            values[i][j] = value
+            found[j] = found[j] || ok
+        }
+    }
+    for j, col := range cols {
+        if !found[j] && len(l.Rows) > 0 {
+            Die(1, "Error. Unknown column '" + col + "'. Columns are: " + strings.Join(l.Fields, ", ") +
+                ", tag:KEY, or any field of the records, e.g. " + GetRecordFieldNames(l.Rows[0].Record))
+        }
+    }
+    return values
+}
+
+
+// Return value of given column of given row, and whether the row has such a column
+func GetRowColumn(row *RowType, col string) (string, bool) {
+    if value, ok := row.Fields.Get(col); ok {
+        return value, true
+    }
+    if strings.HasPrefix(strings.ToLower(col), "tag:") {
+        return GetRecordTag(row.Record, col[4:]), true
+    }
+    v, ok := GetRecordValue(reflect.ValueOf(row.Record), strings.Split(col, "."))
+    if !ok {
+        return "-", false
+    }
+    return FormatRecordValue(v), true
+}
+
+
+// Return given field of given record, where the field can be a dotted path of nested fields,
+// e.g. 'Placement.AvailabilityZone'. Field names are case-insensitive
+func GetRecordField(record interface{}, path string) string {
+    v, ok := GetRecordValue(reflect.ValueOf(record), strings.Split(path, "."))
+    if !ok {
+        return "-"
+    }
+    return FormatRecordValue(v)
+}
+
+
+// Return value of given tag of given record, from its Tags list or map
+func GetRecordTag(record interface{}, key string) string {
+    tags, ok := GetRecordValue(reflect.ValueOf(record), []string{"Tags"})
+    if !ok {
+        return "-"
+    }
+    tags = derefValue(tags)
+    switch tags.Kind() {
+    case reflect.Map:
+        for _, k := range tags.MapKeys() {
+            if k.Kind() == reflect.String && k.String() == key {
+                return FormatRecordValue(tags.MapIndex(k))
+            }
+        }
+    case reflect.Slice:
+        for i := 0 ; i < tags.Len() ; i++ {
+            tag := derefValue(tags.Index(i))
+            if tag.Kind() != reflect.Struct {
+                continue
+            }
+            k, v := derefValue(tag.FieldByName("Key")), tag.FieldByName("Value")
+            if k.IsValid() && k.Kind() == reflect.String && k.String() == key {
+                return FormatRecordValue(v)
+            }
+        }
+    }
+    return "-"
+}
+
+
+// Return the value at given path of field names from given value, looking through pointers,
+// embedded structs and slices, the latter giving a slice of the values from each element
+func GetRecordValue(v reflect.Value, path []string) (reflect.Value, bool) {
+    if len(path) == 0 {
+        return v, true
+    }
+    v = derefValue(v)
+    if !v.IsValid() {
+        return v, true   // A nil along the path, which is fine
+    }
+    switch v.Kind() {
+    case reflect.Struct:
+        name := path[0]
+        field, ok := v.Type().FieldByNameFunc(func(s string) bool { return strings.EqualFold(s, name) })
+        if !ok || field.PkgPath != "" {
+            return reflect.Value{}, false
+        }
+        // Walk the field's index by hand, as embedded pointers may be nil
+        for i, index := range field.Index {
+            if i > 0 {
+                if v = derefValue(v); !v.IsValid() {
+                    return v, true
+                }
+            }
+            v = v.Field(index)
+        }
+        return GetRecordValue(v, path[1:])
+    case reflect.Slice, reflect.Array:
+        values := make([]interface{}, 0, v.Len())
+        for i := 0 ; i < v.Len() ; i++ {
+            elem, ok := GetRecordValue(v.Index(i), path)
+            if !ok {
+                return elem, false
+            }
+            if elem.IsValid() && elem.CanInterface() {
+                values = append(values, elem.Interface())
+            }
+        }
+        return reflect.ValueOf(values), true
+    }
+    return reflect.Value{}, false
+}
+
+
+// Return given value without any pointers or interfaces around it, or an invalid value if nil
+func derefValue(v reflect.Value) reflect.Value {
+    for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+        if v.IsNil() {
+            return reflect.Value{}
+        }
+        v = v.Elem()
+    }
+    return v
+}
+
+
+// Return given record value as a string, the way the listings show them. Lists are space
+// separated, and anything more complex is shown as JSON
+func FormatRecordValue(v reflect.Value) string {
+    v = derefValue(v)
+    if !v.IsValid() {
+        return "-"
+    }
+    if t, ok := v.Interface().(time.Time); ok {
+        return t.Format("2006-01-02 15:04")
+    }
+    switch v.Kind() {
+    case reflect.String:
+        if v.String() == "" {
+            return "-"
+        }
+        return v.String()
+    case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+         reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+         reflect.Float32, reflect.Float64:
+        return fmt.Sprint(v.Interface())
+    case reflect.Slice, reflect.Array:
+        var values []string
+        for i := 0 ; i < v.Len() ; i++ {
+            if s := FormatRecordValue(v.Index(i)); s != "-" {
+                values = append(values, s)
+            }
+        }
+        if len(values) == 0 {
+            return "-"
+        }
+        return strings.Join(values, " ")
+    }
+    b, err := json.Marshal(v.Interface())
+    if err != nil {
+        return "-"
+    }
+    return string(b)
+}
+
+
+// Return comma-separated names of the top-level fields of given record, for error messages
+func GetRecordFieldNames(record interface{}) string {
+    t := reflect.TypeOf(record)
+    for t != nil && t.Kind() == reflect.Ptr {
+        t = t.Elem()
+    }
+    if t == nil || t.Kind() != reflect.Struct {
+        return "-"
+    }
+    var names []string
+    var walk func(t reflect.Type)
+    walk = func(t reflect.Type) {
+        for i := 0 ; i < t.NumField() ; i++ {
+            f := t.Field(i)
+            ft := f.Type
+            if ft.Kind() == reflect.Ptr { ft = ft.Elem() }
+            if f.Anonymous && ft.Kind() == reflect.Struct {
+                walk(ft)   // Promoted fields of the embedded AWS type
+            } else if f.PkgPath == "" {
+                names = append(names, f.Name)
+            }
+        }
+    }
+    walk(t)
+    return strings.Join(names, ", ")
+}
+
+
+// Print each row of this listing with the output template, given the row's fields by name and
+// the record as '.Record'
+func (l *ListingType) PrintTemplate() {
+    for _, row := range l.Rows {
+        data := map[string]interface{}{"Record": row.Record}
+        for _, f := range row.Fields {
+            data[f.Name] = f.Value
+        }
+        var buf bytes.Buffer
+        if err := OutputTemplate.Execute(&buf, data); err != nil {
+            Die(1, "Error. Template failed: " + err.Error())
+        }
+        out := buf.String()
+        if !strings.HasSuffix(out, "\n") {
+            out += "\n"
+        }
+        fmt.Print(out)
+    }
+}
+
+
+// Return function that prints a row of this listing as a table of its fields, in columns
+// aligned across all of its rows
+func (l *ListingType) ColumnPrinter() func(row *RowType) {
+    widths := make([]int, len(l.Fields))
+    for _, row := range l.Rows {
+        for i, f := range row.Fields {
+            if len(f.Value) > widths[i] { widths[i] = len(f.Value) }
+        }
+    }
+    return func(row *RowType) {
+        var line strings.Builder
+        for i, f := range row.Fields {
+            if i == len(row.Fields) - 1 {
+                line.WriteString(f.Value)   // No trailing spaces on the last column
+            } else {
+                line.WriteString(fmt.Sprintf("%-*s  ", widths[i], f.Value))
+            }
+        }
+        fmt.Println(line.String())
+    }
+}
@@ -3,14 +3,11 @@ package main
 
 import (
     "fmt"
-    "net"
-    "time"
     "strings"
     "strconv"
     "errors"
     "encoding/json"
     "github.com/aws/aws-sdk-go/aws"
-    "github.com/aws/aws-sdk-go/aws/session"
     "github.com/aws/aws-sdk-go/service/route53"
     "github.com/aws/aws-sdk-go/aws/awsutil"
 )
@@ -23,6 +20,9 @@ type ResourceRecordSetType struct {
     *route53.ResourceRecordSet
 }
 
+// Register this resource kind with the stores
+var DNSKind = RegisterStoreKind("DNS record", DNSDataFile)
+
 // Return string representation of this type
 func (s ResourceRecordSetType) String() string {
     return awsutil.Prettify(s)
@@ -47,13 +47,20 @@ func (s *ResourceRecordSetType) SetZoneId(v string) *ResourceRecordSetType {
 }
 
 
+// Query fields of DNS records
+var DNSFields = []string{"name", "type", "ttl", "zone", "account", "count", "values"}
+
 // Display all DNS records with applied filter
 func ListDNS(filter string, option string) {
+    query := ParseQueryOrDie(filter, DNSFields)
     list, err := GetDNSList()
     if err != nil {
         Die(1, err.Error())
     }
+    hcList, _ := GetHealthCheckList()   // Only needed for verbose listing
+    listing := NewListing(DNSFields)
     for _, dnsRec := range list {
+        dnsRec := dnsRec   // For the closure below
         dnsName, dnsType, dnsTTL, dnsZoneId, accAlias, dnsCount, dnsValues := GetDetailsOfDNS(dnsRec)
         // DEBUG    
         //fmt.Printf("FIRST:[%s] \n", dnsRec)
@@ -74,47 +81,51 @@ func ListDNS(filter string, option string) {
             }
         }
         Values = strings.TrimSpace(Values)
-        if filter == "" || strContains(dnsName, filter) || strContains(Values, filter) ||
-                           strContains(dnsType, filter) || strContains(dnsTTL, filter) ||
-                           strContains(accAlias, filter) || strContains(dnsZoneId, filter) {
+        fields := NewFieldList(DNSFields, dnsName, dnsType, dnsTTL, dnsZoneId, accAlias,
+            strconv.Itoa(dnsCount), Values)
+        if query.Match(fields) {
             // Notice we never actually display d.ZoneID but we do filter by it
             if option == "-dv" {
-                // Display all records
-                fmt.Printf("%-64s  %-8s  %6s  %-18s  %-2d  %s\n", dnsName, dnsType, dnsTTL, accAlias, dnsCount, Values)
+                // Display all records, and the health checks they depend on
+                listing.Add(fields, dnsRec, func() {
+                    fmt.Printf("%-64s  %-8s  %6s  %-18s  %-2d  %s\n", dnsName, dnsType, dnsTTL, accAlias, dnsCount, Values)
+                    if dnsRec.HealthCheckId != nil {
+                        PrintRecordHealthCheck(*dnsRec.HealthCheckId, hcList)
+                    }
+                })
             } else {
                 // Display only list CNAME, ALIAS, and A records
                 if strings.EqualFold(dnsType, "cname") ||
                    strings.EqualFold(dnsType, "alias") ||
                    strings.EqualFold(dnsType, "a") { 
-                    fmt.Printf("%-64s  %-8s  %6s  %-18s  %-2d  %s\n", dnsName, dnsType, dnsTTL, accAlias, dnsCount, Values)
+                    listing.Add(fields, dnsRec, func() {
+                        fmt.Printf("%-64s  %-8s  %6s  %-18s  %-2d  %s\n", dnsName, dnsType, dnsTTL, accAlias, dnsCount, Values)
+                    })
                 }
             }                
         }
     }
+    listing.Flush()
     return
 }
 
 
-// Return dns records list in local or remote store
-func GetDNSList() (list []ResourceRecordSetType, err error) {
-    localFileTimestamp := GetLocalFileTime(DNSDataFile)
-    remoteFileTimestamp := GetRemoteFileTime(DNSDataFile)
-
-    // Use remote S3 file if it's newer
-    if remoteFileTimestamp.After(localFileTimestamp) {
-        tmplist, err := GetListFromRemote(DNSDataFile)
-        list = tmplist.([]ResourceRecordSetType)  // Assert our DNS type
-        if err == nil {
-            WriteList(list, DNSDataFile)          // Update local with this newer set
-            return list, nil
-        }
-        // Return what must be an empty list with the error code
-        return list, err
+// Print given health check of a DNS record, indented under it
+func PrintRecordHealthCheck(hcId string, hcList []HealthCheckType) {
+    hc := GetHealthCheckById(hcId, hcList)
+    if hc == nil {
+        fmt.Printf("    healthcheck  %s not found in health check store\n", hcId)
+        return
     }
+    a, b, c, _, _, f, _ := GetDetailsOfHealthCheck(*hc)
+    // a = Id    b = Type    c = Endpoint    f = Status
+    fmt.Printf("    healthcheck  %-36s  %-16s  %-64s  %s\n", a, b, c, f)
+}
+
 
-    // Else, just return local file content with error code
-    tmplist, err := GetListFromLocal(DNSDataFile)
-    list = tmplist.([]ResourceRecordSetType)
+// Return DNS records list from the data store
+func GetDNSList() (list []ResourceRecordSetType, err error) {
+    err = DataStore.Load(DNSKind, &list)
     return list, err
 }
 
@@ -163,44 +174,6 @@ func GetDetailsOfDNS(dnsRec ResourceRecordSetType) (dnsName string,
 }
 
 
-// Breakdown given DNS name into its ELB/instances backend components
-func BreakdownDNS(dnsName string) {
-    // Skip all CNAMEs until we find the A record at the end
-    lastARec := dnsName
-    for {
-        resp, err := net.LookupCNAME(lastARec)
-        if err != nil {
-            Die(1, err.Error())          // Abort if record points to nowhere
-        }
-        respRec := NormalDNSName(resp)   // Normalize DNS name
-        if strings.EqualFold(respRec, lastARec) {
-            lastARec = respRec           // Exit for-loop if same as last result
-            break
-        }
-        lastARec = respRec               // Try again with this last record
-    }
-
-    // If last-A-record is one of our ELB DNS names then do ELB breakdown
-    _, err := GetELBFromLocal(lastARec)
-    if err == nil {
-        BreakdownELB(lastARec)
-        return
-    }
-
-    // If last-A-record is one our DNS records and points to one of our ELBs (ALIAS record) then do ELB breakdown
-    dns, err := GetDNSFromLocal(lastARec)
-    if err == nil {
-        if dns.AliasTarget != nil {
-            elbDNSName := dns.AliasTarget.DNSName
-            if elbDNSName != nil {
-                BreakdownELB(NormalDNSName(*elbDNSName))
-            }
-        }
-    }
-    return
-}
-
-
 // Normalize DNS name by removing superfluous 'dualstack.' and '.' strings
 func NormalDNSName(dnsName string) string {
     str := strings.TrimPrefix(dnsName, "dualstack.")
@@ -226,8 +199,8 @@ func GetDNSFromLocal(dnsName string) (dns ResourceRecordSetType, err error) {
 }
 
 
-// Update local copy of DNS records from current AWS account
-func UpdateLocalDNSStoreFromAWS(targetZones []string, minutesAgo int) {
+// Update local copy of DNS records from given AWS accounts
+func UpdateLocalDNSStoreFromAWS(accounts []*AccountType, targetZones []string, minutesAgo int) {
     // Note that DNS updates _have_ to be done by going thru each DNS zone
 
     // Based new target zone list on current zone list and whether we're updating
@@ -242,8 +215,28 @@ func UpdateLocalDNSStoreFromAWS(targetZones []string, minutesAgo int) {
         fmt.Printf("Updating local DNS store for all domains (can take a long time)\n")
         targetZoneList = currentZoneList
     } else if len(targetZones) == 0 && minutesAgo > 0 {
-        // We'll update DNS records ONLY in zones modified within minutesAgo
-        updatedZoneIdList := GetUpdatedZoneIdList(minutesAgo)
+        // We'll update DNS records ONLY in zones modified within minutesAgo, in any account
+        zoneIdLists := make([][]string, len(accounts))
+        RunParallel("cloudtrail", len(accounts), func(i int) {
+            var err error
+            zoneIdLists[i], err = GetUpdatedZoneIdList(accounts[i], minutesAgo)
+            if err != nil {
+                // Can't tell which zones changed, so update all of this account's zones
+                fmt.Printf("  Unable to check CloudTrail for %s, updating all its zones: %s\n",
+                    accounts[i].Alias, err.Error())
+                for _, zone := range currentZoneList {
+                    if zone.AccountId != nil && *zone.AccountId == accounts[i].Id {
+                        zoneIdLists[i] = append(zoneIdLists[i], *zone.Id)
+                    }
+                }
+            }
+        })
+        var updatedZoneIdList []string
+        for _, zoneIdList := range zoneIdLists {
+            for _, zoneId := range zoneIdList {
+                updatedZoneIdList = AppendIfMissing(updatedZoneIdList, zoneId)
+            }
+        }
         updatedZoneIdListCount := len(updatedZoneIdList)
         if updatedZoneIdListCount < 1 {
             fmt.Printf("Skipping local DNS store update (no mods within %d minutes)\n",
@@ -273,80 +266,86 @@ func UpdateLocalDNSStoreFromAWS(targetZones []string, minutesAgo int) {
         }
     }
 
-    // Build a list of the target zone Ids to simplify logic below
-    var targetZoneListIds []string
+    // We can only update records for zones in the given AWS accounts, so skip
+    // any zone not in one of them
+    var zoneList []HostedZoneType
     for _, zone := range targetZoneList {
-        targetZoneListIds = append(targetZoneListIds, *zone.Id)
+        if GetAccountById(accounts, zone.AccountId) != nil {
+            zoneList = append(zoneList, zone)
+        }
+    }
+
+    // Now get the DNS records of each target zone concurrently
+    results := make([][]ResourceRecordSetType, len(zoneList))
+    errs := make([]error, len(zoneList))
+    RunParallel("route53", len(zoneList), func(i int) {
+        zone := zoneList[i]
+        results[i], errs[i] = GetDNSListByZoneIdFromAWS(GetAccountById(accounts, zone.AccountId), *zone.Id)
+
+        // Print some info in the process
+        zoneName := strings.TrimSuffix(*zone.Name, ".")
+        if errs[i] == nil {
+            fmt.Printf("  Updating zone: %s [%d]\n", zoneName, len(results[i]))
+        }
+    })
+
+    // Build a list of the Ids of the zones we did get, to simplify logic below. Zones that
+    // failed keep their previous records
+    var updatedZoneListIds []string
+    for i, zone := range zoneList {
+        acct := GetAccountById(accounts, zone.AccountId)
+        zoneName := strings.TrimSuffix(*zone.Name, ".")
+        if UpdateReport.Add(DNSKind.Name, acct, zoneName, errs[i]) {
+            updatedZoneListIds = append(updatedZoneListIds, *zone.Id)
+        }
+    }
+    if len(updatedZoneListIds) == 0 {
+        return   // Nothing to update
     }
 
     // Build a brand new list from existing DNS store
-    var list []ResourceRecordSetType 
+    var list []ResourceRecordSetType
     dnsList, _ := GetDNSList()
     for _, dns := range dnsList {
-        // If this record belongs to one of the target zones, AND it's in the current
-        // AWS account then let's skip it so we can update its value in the next for-loop
-        if strInList(*dns.ZoneId, targetZoneListIds) && *dns.AccountId == AWSAccountId {
+        // If this record belongs to one of the updated zones, AND it's in one of the
+        // given AWS accounts then let's skip it so we can replace it with its new value
+        if strInList(*dns.ZoneId, updatedZoneListIds) && GetAccountById(accounts, dns.AccountId) != nil {
             continue
         }
         // Add this record, leaving as is, since we won't be updating it
         list = append(list, dns)
     }
 
-    // Now cycle thru each target zone, updating their DNS records in our growing list
-    for _, zone := range targetZoneList {
-        // We can only update records for zones in the current AWS account, so skip
-        // any zone not in the current account
-        if *zone.AccountId != AWSAccountId {
-            continue
-        }
-
-        // Now get all records for this zone, and add them to this new list
-        dnsList := GetDNSListByZoneIdFromAWS(*zone.Id)
-
-        // Print some info in the process
-        zoneName := strings.TrimSuffix(*zone.Name, ".")
-        fmt.Printf("  Updating zone: %s [%d]\n", zoneName, len(dnsList))
-
-        for _, dns := range dnsList {
-            list = append(list, dns)
-        }
+    // And add all the new records to our growing list
+    for _, result := range results {
+        list = append(list, result...)
     }
 
     // Make this the new local list
-    WriteList(list, DNSDataFile)
+    WriteList(list, DNSKind)
     return
 }
 
 
-// Return all DNS objects for given zone ID, from AWS
-func GetDNSListByZoneIdFromAWS(zoneId string) (list []ResourceRecordSetType) {
-    SetAWSRegion()
-    sess := session.Must(session.NewSession())
-    svc := route53.New(sess, aws.NewConfig().WithRegion(AWSRegion))
+// Return all DNS objects for given zone ID, from given AWS account
+func GetDNSListByZoneIdFromAWS(acct *AccountType, zoneId string) (list []ResourceRecordSetType, err error) {
+    svc := route53.New(acct.Sess, AWSConfig(AWSRegion))
 
     params := &route53.ListResourceRecordSetsInput{
         HostedZoneId: aws.String(zoneId),
         MaxItems: aws.String("100"),  // 100 is an AWS limit
     }
 
-    // Loop requests in case there're more than PageSize records or we're being throttled
-    errcount := 0
+    // Loop requests in case there're more than PageSize records
     for {
-        // Get batch of records
-        resp, err := svc.ListResourceRecordSets(params)
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *route53.ListResourceRecordSetsOutput
+        err := CallWithRetry("route53", func() (err error) {
+            resp, err = svc.ListResourceRecordSets(params)
+            return err
+        })
         if err != nil {
-            // Sleep for a moment if AWS is throttling us
-            if BeingThrottled(err) {
-                fmt.Printf("  AWS throttling. Sleeping %d seconds...\n", APISecondsDelay)
-                time.Sleep(time.Duration(APISecondsDelay) * time.Second)
-                continue
-            }
-            // Allow for 3 other unknown API call errors before panicking
-            if errcount < 3 {
-                errcount++
-                continue
-            }
-            panic(err.Error())   // Abort on any other error
+            return nil, err
         }
 
         // Ensure valid data came back
@@ -355,19 +354,19 @@ func GetDNSListByZoneIdFromAWS(zoneId string) (list []ResourceRecordSetType) {
             // First convert it to raw []byte
             jsonData, err := json.Marshal(resp.ResourceRecordSets)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Now read it into extended type list
             var dnsList []ResourceRecordSetType
             err = json.Unmarshal(jsonData, &dnsList)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Add this batch to our list
             for _, dns := range dnsList {
                 // Add our additional fields
-                dns = *dns.SetAccountAlias(AWSAccountAlias)
-                dns = *dns.SetAccountId(AWSAccountId)
+                dns = *dns.SetAccountAlias(acct.Alias)
+                dns = *dns.SetAccountId(acct.Id)
                 dns = *dns.SetZoneId(zoneId)
                 list = append(list, dns)
             }
@@ -380,5 +379,5 @@ func GetDNSListByZoneIdFromAWS(zoneId string) (list []ResourceRecordSetType) {
             params.StartRecordName = resp.NextRecordName
         }
     }
-    return list
+    return list, nil
 }
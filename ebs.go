@@ -0,0 +1,423 @@
+// ebs.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "strconv"
+    "encoding/json"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/ec2"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS ec2.Volume type to include these additional fields
+type EBSVolumeType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.Volume
+}
+
+// Extend AWS ec2.Snapshot type to include these additional fields
+type SnapshotType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.Snapshot
+}
+
+// Register these resource kinds with the stores
+var (
+    VolumeKind   = RegisterStoreKind("EBS volume", VolumeDataFile)
+    SnapshotKind = RegisterStoreKind("EBS snapshot", SnapshotDataFile)
+)
+
+// Return string representation of this type
+func (s EBSVolumeType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *EBSVolumeType) SetAccountAlias(v string) *EBSVolumeType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *EBSVolumeType) SetAccountId(v string) *EBSVolumeType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *EBSVolumeType) SetRegion(v string) *EBSVolumeType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s SnapshotType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *SnapshotType) SetAccountAlias(v string) *SnapshotType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *SnapshotType) SetAccountId(v string) *SnapshotType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *SnapshotType) SetRegion(v string) *SnapshotType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all EBS volumes with applied filter. With option -vu only display the unattached ones
+func ListVolumes(filter string, option string) {
+    list, err := GetVolumeList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    for _, vol := range list {
+        if option == "-vu" && len(vol.Attachments) > 0 {
+            continue
+        }
+        a, b, c, d, e, f, g, h, k, l := GetDetailsOfVolume(vol)
+        tags := GetEC2TagString(vol.Tags)
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(f, filter) || strContains(g, filter) || strContains(h, filter) ||
+           strContains(k, filter) || strContains(l, filter) || strContains(tags, filter) {
+            fmt.Printf("%-22s  %-30s  %5s  %-8s  %6s  %-9s  %-10s  %-32s  %-18s  %s\n",
+                a, b, c, d, e, f, g, h, k, l)
+        }
+    }
+}
+
+
+// Display all EBS snapshots with applied filter
+func ListSnapshots(filter string) {
+    list, err := GetSnapshotList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    for _, snap := range list {
+        a, b, c, d, e, f, g, h, k := GetDetailsOfSnapshot(snap)
+        tags := GetEC2TagString(snap.Tags)
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(f, filter) || strContains(g, filter) || strContains(h, filter) ||
+           strContains(k, filter) || strContains(tags, filter) {
+            fmt.Printf("%-22s  %-22s  %5s  %-9s  %-16s  %-9s  %-18s  %-14s  %s\n",
+                a, b, c, d, e, f, g, h, k)
+        }
+    }
+}
+
+
+// Return EBS volume records list from the data store
+func GetVolumeList() (list []EBSVolumeType, err error) {
+    err = DataStore.Load(VolumeKind, &list)
+    return list, err
+}
+
+
+// Return EBS snapshot records list from the data store
+func GetSnapshotList() (list []SnapshotType, err error) {
+    err = DataStore.Load(SnapshotKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object. Size is in GiB, and attachment is shown as
+// 'INSTANCEID:DEVICE'
+func GetDetailsOfVolume(vol EBSVolumeType) (volId, name, size, volType, iops, encrypted, state,
+                                            attachment, acctAlias, region string) {
+    volId, name, size, volType, iops, encrypted = "-", "-", "-", "-", "-", "-"
+    state, attachment, acctAlias, region = "-", "-", "-", "-"
+
+    if vol.Volume == nil {
+        return
+    }
+    if vol.VolumeId != nil { volId = *vol.VolumeId }
+    name = GetNameTag(vol.Tags)
+    if vol.Size != nil { size = strconv.FormatInt(*vol.Size, 10) }
+    if vol.VolumeType != nil { volType = *vol.VolumeType }
+    if vol.Iops != nil { iops = strconv.FormatInt(*vol.Iops, 10) }
+    if vol.Encrypted != nil && *vol.Encrypted { encrypted = "encrypted" }
+    if vol.State != nil { state = *vol.State }
+    var attached []string
+    for _, att := range vol.Attachments {
+        if att != nil && att.InstanceId != nil {
+            attached = append(attached, *att.InstanceId + ":" + aws.StringValue(att.Device))
+        }
+    }
+    if len(attached) > 0 { attachment = strings.Join(attached, ",") }
+    if vol.AccountAlias != nil { acctAlias = *vol.AccountAlias }
+    if vol.Region != nil { region = *vol.Region }
+    return
+}
+
+
+// Return important attributes of given object. Size is in GiB
+func GetDetailsOfSnapshot(snap SnapshotType) (snapId, volId, size, state, startTime, encrypted,
+                                              acctAlias, region, description string) {
+    snapId, volId, size, state, startTime = "-", "-", "-", "-", "-"
+    encrypted, acctAlias, region, description = "-", "-", "-", "-"
+
+    if snap.Snapshot == nil {
+        return
+    }
+    if snap.SnapshotId != nil { snapId = *snap.SnapshotId }
+    if snap.VolumeId != nil { volId = *snap.VolumeId }
+    if snap.VolumeSize != nil { size = strconv.FormatInt(*snap.VolumeSize, 10) }
+    if snap.State != nil { state = *snap.State }
+    if snap.StartTime != nil { startTime = snap.StartTime.Format("2006-01-02 15:04") }
+    if snap.Encrypted != nil && *snap.Encrypted { encrypted = "encrypted" }
+    if snap.AccountAlias != nil { acctAlias = *snap.AccountAlias }
+    if snap.Region != nil { region = *snap.Region }
+    if snap.Description != nil && *snap.Description != "" { description = *snap.Description }
+    return
+}
+
+
+// Return given EC2 tags as a sorted, space-separated string of KEY=VALUE pairs
+func GetEC2TagString(tags []*ec2.Tag) string {
+    var list []string
+    for _, tag := range tags {
+        if tag != nil && tag.Key != nil {
+            list = append(list, *tag.Key + "=" + aws.StringValue(tag.Value))
+        }
+    }
+    sort.Strings(list)
+    return strings.Join(list, " ")
+}
+
+
+// Return given volumes keyed by the instance IDs they're attached to
+func GetVolumesByInstance(volList []EBSVolumeType) map[string][]EBSVolumeType {
+    volumes := map[string][]EBSVolumeType{}
+    for _, vol := range volList {
+        if vol.Volume == nil {
+            continue
+        }
+        for _, att := range vol.Attachments {
+            if att != nil && att.InstanceId != nil {
+                volumes[*att.InstanceId] = append(volumes[*att.InstanceId], vol)
+            }
+        }
+    }
+    return volumes
+}
+
+
+// Print given instance's volumes, one per line, with the device each one is attached as
+func PrintInstanceVolumes(instId string, volumes []EBSVolumeType, indent string) {
+    for _, vol := range volumes {
+        device := "-"
+        for _, att := range vol.Attachments {
+            if att != nil && aws.StringValue(att.InstanceId) == instId && att.Device != nil {
+                device = *att.Device
+            }
+        }
+        a, b, c, d, e, f, _, _, _, _ := GetDetailsOfVolume(vol)
+        // a = VolumeId    b = Name    c = Size    d = Type    e = IOPS    f = Encrypted
+        fmt.Printf("%s%-12s  %-22s  %5s  %-8s  %6s  %-9s  %s\n", indent, device, a, c, d, e, f, b)
+    }
+}
+
+
+// Update local EBS volume and snapshot stores from given AWS accounts
+func UpdateLocalEBSStoresFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("ec2", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local EBS volume and snapshot stores.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no EC2 events within last minutesAgo
+            fmt.Printf("Skipping local EBS volume and snapshot stores update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local EBS volume and snapshot stores (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    volResults := make([][]EBSVolumeType, len(sweeps))
+    volErrs := make([]error, len(sweeps))
+    snapResults := make([][]SnapshotType, len(sweeps))
+    snapErrs := make([]error, len(sweeps))
+    RunParallel("ec2", len(sweeps), func(i int) {
+        acct, region := sweeps[i].Account, sweeps[i].Region
+        volResults[i], volErrs[i] = GetVolumeListFromAWS(acct, region)
+        snapResults[i], snapErrs[i] = GetSnapshotListFromAWS(acct, region)
+    })
+
+    // Keep previous volume records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(VolumeKind.Name, sweep.Account, sweep.Region, volErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        // Create a new list from existing store, without the ones for the updated accounts and regions
+        var list []EBSVolumeType
+        volList, _ := GetVolumeList()
+        for _, vol := range volList {
+            if !RecordInSweep(vol.AccountId, vol.Region, updated) {
+                list = append(list, vol)
+            }
+        }
+        // Now add all the new records to this new list, and make it the new local list
+        for _, result := range volResults {
+            list = append(list, result...)
+        }
+        WriteList(list, VolumeKind)
+    }
+
+    // Same again for the snapshots
+    updated = nil
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(SnapshotKind.Name, sweep.Account, sweep.Region, snapErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        var list []SnapshotType
+        snapList, _ := GetSnapshotList()
+        for _, snap := range snapList {
+            if !RecordInSweep(snap.AccountId, snap.Region, updated) {
+                list = append(list, snap)
+            }
+        }
+        for _, result := range snapResults {
+            list = append(list, result...)
+        }
+        WriteList(list, SnapshotKind)
+    }
+    return
+}
+
+
+// Return all EBS volume objects in given account and region
+func GetVolumeListFromAWS(acct *AccountType, region string) (list []EBSVolumeType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    params := &ec2.DescribeVolumesInput{
+        MaxResults: aws.Int64(500),  // 500 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxResults records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeVolumesOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeVolumes(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.Volumes != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.Volumes)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var volList []EBSVolumeType
+            err = json.Unmarshal(jsonData, &volList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, vol := range volList {
+                // Add our additional fields
+                vol = *vol.SetAccountAlias(acct.Alias)
+                vol = *vol.SetAccountId(acct.Id)
+                vol = *vol.SetRegion(region)
+                list = append(list, vol)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
+
+
+// Return all EBS snapshot objects owned by given account in given region. Public and shared
+// snapshots are left out
+func GetSnapshotListFromAWS(acct *AccountType, region string) (list []SnapshotType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    params := &ec2.DescribeSnapshotsInput{
+        OwnerIds:   aws.StringSlice([]string{"self"}),
+        MaxResults: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxResults records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeSnapshotsOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeSnapshots(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.Snapshots != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.Snapshots)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var snapList []SnapshotType
+            err = json.Unmarshal(jsonData, &snapList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, snap := range snapList {
+                // Add our additional fields
+                snap = *snap.SetAccountAlias(acct.Alias)
+                snap = *snap.SetAccountId(acct.Id)
+                snap = *snap.SetRegion(region)
+                list = append(list, snap)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
@@ -0,0 +1,619 @@
+// ecs.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/ecs"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS ecs.Cluster type to include these additional fields
+type ECSClusterType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ecs.Cluster
+}
+
+// Extend AWS ecs.Service type to include these additional fields
+type ECSServiceType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ecs.Service
+}
+
+// Extend AWS ecs.Task type to include these additional fields
+type ECSTaskType struct {
+    AccountAlias   *string
+    AccountId      *string
+    Region         *string
+    Ec2InstanceId  *string   // Instance the task runs on, nil for Fargate tasks
+    *ecs.Task
+}
+
+// Register these resource kinds with the stores
+var (
+    ECSClusterKind = RegisterStoreKind("ECS cluster", ECSClusterDataFile)
+    ECSServiceKind = RegisterStoreKind("ECS service", ECSServiceDataFile)
+    ECSTaskKind    = RegisterStoreKind("ECS task", ECSTaskDataFile)
+)
+
+// Return string representation of this type
+func (s ECSClusterType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *ECSClusterType) SetAccountAlias(v string) *ECSClusterType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *ECSClusterType) SetAccountId(v string) *ECSClusterType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *ECSClusterType) SetRegion(v string) *ECSClusterType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s ECSServiceType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *ECSServiceType) SetAccountAlias(v string) *ECSServiceType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *ECSServiceType) SetAccountId(v string) *ECSServiceType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *ECSServiceType) SetRegion(v string) *ECSServiceType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s ECSTaskType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *ECSTaskType) SetAccountAlias(v string) *ECSTaskType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *ECSTaskType) SetAccountId(v string) *ECSTaskType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *ECSTaskType) SetRegion(v string) *ECSTaskType {
+    s.Region = &v
+    return s
+}
+
+// Set Ec2InstanceId field's value
+func (s *ECSTaskType) SetEc2InstanceId(v string) *ECSTaskType {
+    s.Ec2InstanceId = &v
+    return s
+}
+
+
+// Display all ECS clusters and their services with applied filter. With option -cv also
+// display each service's running tasks
+func ListECS(filter string, option string) {
+    clusterList, err := GetECSClusterList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    serviceList, _ := GetECSServiceList()
+    taskList, _ := GetECSTaskList()
+
+    for _, cluster := range clusterList {
+        a, b, c, d, e, f, g := GetDetailsOfECSCluster(cluster)
+        clusterMatch := filter == "" || strContains(a, filter) || strContains(b, filter) ||
+                        strContains(f, filter) || strContains(g, filter)
+
+        // Show all services of a matching cluster, else only the matching services
+        var services []ECSServiceType
+        for _, service := range serviceList {
+            if aws.StringValue(service.ClusterArn) != aws.StringValue(cluster.ClusterArn) {
+                continue
+            }
+            if clusterMatch || ECSServiceMatches(service, filter, taskList) {
+                services = append(services, service)
+            }
+        }
+        if !clusterMatch && len(services) == 0 {
+            continue
+        }
+
+        fmt.Printf("%-40s  %-10s  %4s  %4s  %4s  %-18s  %s\n", a, b, c, d, e, f, g)
+        for _, service := range services {
+            name, desired, running, pending, launchType, taskDef, lbs := GetDetailsOfECSService(service)
+            fmt.Printf("  %-48s  %4s  %4s  %4s  %-8s  %-40s  %s\n",
+                name, desired, running, pending, launchType, taskDef, lbs)
+            if option != "-cv" {
+                continue
+            }
+            for _, task := range GetECSTasksOfService(service, taskList) {
+                id, status, instId, ips := GetDetailsOfECSTask(task)
+                fmt.Printf("    %-36s  %-10s  %-20s  %s\n", id, status, instId, ips)
+            }
+        }
+    }
+}
+
+
+// Check if given service, or any of its tasks, match given filter
+func ECSServiceMatches(service ECSServiceType, filter string, taskList []ECSTaskType) bool {
+    a, _, _, _, e, f, g := GetDetailsOfECSService(service)
+    if strContains(a, filter) || strContains(e, filter) || strContains(f, filter) ||
+       strContains(g, filter) {
+        return true
+    }
+    for _, task := range GetECSTasksOfService(service, taskList) {
+        id, _, instId, ips := GetDetailsOfECSTask(task)
+        if strContains(id, filter) || strContains(instId, filter) || strContains(ips, filter) {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Return ECS cluster records list from the data store
+func GetECSClusterList() (list []ECSClusterType, err error) {
+    err = DataStore.Load(ECSClusterKind, &list)
+    return list, err
+}
+
+
+// Return ECS service records list from the data store
+func GetECSServiceList() (list []ECSServiceType, err error) {
+    err = DataStore.Load(ECSServiceKind, &list)
+    return list, err
+}
+
+
+// Return ECS task records list from the data store
+func GetECSTaskList() (list []ECSTaskType, err error) {
+    err = DataStore.Load(ECSTaskKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfECSCluster(cluster ECSClusterType) (name, status, services, tasks, instances,
+                                                     acctAlias, region string) {
+    name, status, services, tasks, instances, acctAlias, region = "-", "-", "-", "-", "-", "-", "-"
+
+    if cluster.ClusterName != nil { name = *cluster.ClusterName }
+    if cluster.Status != nil { status = *cluster.Status }
+    if cluster.ActiveServicesCount != nil {
+        services = strconv.FormatInt(*cluster.ActiveServicesCount, 10)
+    }
+    if cluster.RunningTasksCount != nil {
+        tasks = strconv.FormatInt(*cluster.RunningTasksCount, 10)
+    }
+    if cluster.RegisteredContainerInstancesCount != nil {
+        instances = strconv.FormatInt(*cluster.RegisteredContainerInstancesCount, 10)
+    }
+    if cluster.AccountAlias != nil { acctAlias = *cluster.AccountAlias }
+    if cluster.Region != nil { region = *cluster.Region }
+    return
+}
+
+
+// Return important attributes of given object. Load balancers are shown as classic ELB names
+// and target group names
+func GetDetailsOfECSService(service ECSServiceType) (name, desired, running, pending, launchType,
+                                                     taskDef, lbs string) {
+    name, desired, running, pending, launchType, taskDef, lbs = "-", "-", "-", "-", "-", "-", "-"
+
+    if service.ServiceName != nil { name = *service.ServiceName }
+    if service.DesiredCount != nil { desired = strconv.FormatInt(*service.DesiredCount, 10) }
+    if service.RunningCount != nil { running = strconv.FormatInt(*service.RunningCount, 10) }
+    if service.PendingCount != nil { pending = strconv.FormatInt(*service.PendingCount, 10) }
+    if service.LaunchType != nil { launchType = *service.LaunchType }
+    if service.TaskDefinition != nil { taskDef = GetLastArnPart(*service.TaskDefinition) }
+    var names []string
+    for _, lb := range service.LoadBalancers {
+        if lb == nil {
+            continue
+        }
+        if lb.LoadBalancerName != nil {
+            names = append(names, *lb.LoadBalancerName)
+        } else if lb.TargetGroupArn != nil {
+            // Target group ARNs end in 'targetgroup/NAME/ID'
+            parts := strings.Split(*lb.TargetGroupArn, "/")
+            if len(parts) >= 2 { names = append(names, parts[1]) }
+        }
+    }
+    if len(names) > 0 { lbs = strings.Join(names, ",") }
+    return
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfECSTask(task ECSTaskType) (taskId, status, instId, ips string) {
+    taskId, status, instId, ips = "-", "-", "-", "-"
+
+    if task.TaskArn != nil { taskId = GetLastArnPart(*task.TaskArn) }
+    if task.LastStatus != nil { status = *task.LastStatus }
+    if task.Ec2InstanceId != nil { instId = *task.Ec2InstanceId }
+    if list := GetECSTaskIPs(task); len(list) > 0 { ips = strings.Join(list, " ") }
+    return
+}
+
+
+// Return private IPs of given task's containers, which only awsvpc network mode tasks have
+func GetECSTaskIPs(task ECSTaskType) (ips []string) {
+    for _, c := range task.Containers {
+        if c == nil {
+            continue
+        }
+        for _, nic := range c.NetworkInterfaces {
+            if nic != nil && nic.PrivateIpv4Address != nil && !strInList(*nic.PrivateIpv4Address, ips) {
+                ips = append(ips, *nic.PrivateIpv4Address)
+            }
+        }
+    }
+    return ips
+}
+
+
+// Return the last part of given ARN, e.g., the task ID of a task ARN
+func GetLastArnPart(arn string) string {
+    parts := strings.Split(arn, "/")
+    return parts[len(parts) - 1]
+}
+
+
+// Return tasks of given service from given list. Service tasks are in group 'service:NAME'
+func GetECSTasksOfService(service ECSServiceType, taskList []ECSTaskType) (list []ECSTaskType) {
+    group := "service:" + aws.StringValue(service.ServiceName)
+    for _, task := range taskList {
+        if task.Task != nil && aws.StringValue(task.Group) == group &&
+           aws.StringValue(task.ClusterArn) == aws.StringValue(service.ClusterArn) {
+            list = append(list, task)
+        }
+    }
+    return list
+}
+
+
+// Return name of the ECS service bound to given target group ARN or classic ELB name that runs
+// a task on given target, which is an instance ID or an IP, or an empty string if there's none
+func GetECSServiceNameOfTarget(targetId, lbKey string, serviceList []ECSServiceType,
+                               taskList []ECSTaskType) string {
+    for _, service := range serviceList {
+        bound := false
+        for _, lb := range service.LoadBalancers {
+            if lb != nil && (aws.StringValue(lb.TargetGroupArn) == lbKey ||
+                             aws.StringValue(lb.LoadBalancerName) == lbKey) {
+                bound = true
+                break
+            }
+        }
+        if !bound {
+            continue
+        }
+        for _, task := range GetECSTasksOfService(service, taskList) {
+            if aws.StringValue(task.Ec2InstanceId) == targetId || strInList(targetId, GetECSTaskIPs(task)) {
+                return aws.StringValue(service.ServiceName)
+            }
+        }
+    }
+    return ""
+}
+
+
+// Update local copies of ECS cluster, service and task records from given AWS accounts
+func UpdateLocalECSStoresFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("ecs", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local ECS stores.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no ECS events within last minutesAgo
+            fmt.Printf("Skipping local ECS stores update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local ECS stores (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently. Services and tasks are found
+    // thru their clusters, so they're all collected together
+    clusterResults := make([][]ECSClusterType, len(sweeps))
+    serviceResults := make([][]ECSServiceType, len(sweeps))
+    taskResults := make([][]ECSTaskType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("ecs", len(sweeps), func(i int) {
+        clusterResults[i], serviceResults[i], taskResults[i], errs[i] =
+            GetECSListsFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(ECSClusterKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create new lists from existing stores, without the ones for the updated accounts and regions,
+    // and add all the new records to them
+    var clusters []ECSClusterType
+    clusterList, _ := GetECSClusterList()
+    for _, cluster := range clusterList {
+        if !RecordInSweep(cluster.AccountId, cluster.Region, updated) {
+            clusters = append(clusters, cluster)
+        }
+    }
+    var services []ECSServiceType
+    serviceList, _ := GetECSServiceList()
+    for _, service := range serviceList {
+        if !RecordInSweep(service.AccountId, service.Region, updated) {
+            services = append(services, service)
+        }
+    }
+    var tasks []ECSTaskType
+    taskList, _ := GetECSTaskList()
+    for _, task := range taskList {
+        if !RecordInSweep(task.AccountId, task.Region, updated) {
+            tasks = append(tasks, task)
+        }
+    }
+    for i := range sweeps {
+        clusters = append(clusters, clusterResults[i]...)
+        services = append(services, serviceResults[i]...)
+        tasks = append(tasks, taskResults[i]...)
+    }
+
+    // Make these the new local lists
+    WriteList(clusters, ECSClusterKind)
+    WriteList(services, ECSServiceKind)
+    WriteList(tasks, ECSTaskKind)
+    return
+}
+
+
+// Return all ECS clusters in given account and region, with all their services and running tasks
+func GetECSListsFromAWS(acct *AccountType, region string) (clusters []ECSClusterType,
+                        services []ECSServiceType, tasks []ECSTaskType, err error) {
+    svc := ecs.New(acct.Sess, AWSConfig(region))
+
+    clusterArns, err := GetECSArnsFromAWS(func(token *string) ([]*string, *string, error) {
+        resp, err := svc.ListClusters(&ecs.ListClustersInput{
+            MaxResults: aws.Int64(100),  // 100 is AWS max request limit
+            NextToken:  token,
+        })
+        if err != nil {
+            return nil, nil, err
+        }
+        return resp.ClusterArns, resp.NextToken, nil
+    })
+    if err != nil {
+        return nil, nil, nil, err
+    }
+
+    for _, batch := range GetArnBatches(clusterArns, 100) {
+        var resp *ecs.DescribeClustersOutput
+        err := CallWithRetry("ecs", func() (err error) {
+            resp, err = svc.DescribeClusters(&ecs.DescribeClustersInput{Clusters: batch})
+            return err
+        })
+        if err != nil {
+            return nil, nil, nil, err
+        }
+        for _, c := range resp.Clusters {
+            if c == nil || c.ClusterArn == nil {
+                continue
+            }
+            cluster := ECSClusterType{Cluster: c}
+            cluster = *cluster.SetAccountAlias(acct.Alias)
+            cluster = *cluster.SetAccountId(acct.Id)
+            cluster = *cluster.SetRegion(region)
+            clusters = append(clusters, cluster)
+
+            clusterServices, err := GetECSServiceListFromAWS(svc, c.ClusterArn)
+            if err != nil {
+                return nil, nil, nil, err
+            }
+            for _, service := range clusterServices {
+                service = *service.SetAccountAlias(acct.Alias)
+                service = *service.SetAccountId(acct.Id)
+                service = *service.SetRegion(region)
+                services = append(services, service)
+            }
+
+            clusterTasks, err := GetECSTaskListFromAWS(svc, c.ClusterArn)
+            if err != nil {
+                return nil, nil, nil, err
+            }
+            for _, task := range clusterTasks {
+                task = *task.SetAccountAlias(acct.Alias)
+                task = *task.SetAccountId(acct.Id)
+                task = *task.SetRegion(region)
+                tasks = append(tasks, task)
+            }
+        }
+    }
+    return clusters, services, tasks, nil
+}
+
+
+// Return all services in given cluster
+func GetECSServiceListFromAWS(svc *ecs.ECS, clusterArn *string) (list []ECSServiceType, err error) {
+    serviceArns, err := GetECSArnsFromAWS(func(token *string) ([]*string, *string, error) {
+        resp, err := svc.ListServices(&ecs.ListServicesInput{
+            Cluster:    clusterArn,
+            MaxResults: aws.Int64(100),  // 100 is AWS max request limit
+            NextToken:  token,
+        })
+        if err != nil {
+            return nil, nil, err
+        }
+        return resp.ServiceArns, resp.NextToken, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    for _, batch := range GetArnBatches(serviceArns, 10) {   // 10 is AWS max describe limit
+        var resp *ecs.DescribeServicesOutput
+        err := CallWithRetry("ecs", func() (err error) {
+            resp, err = svc.DescribeServices(&ecs.DescribeServicesInput{
+                Cluster:  clusterArn,
+                Services: batch,
+            })
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, s := range resp.Services {
+            if s != nil {
+                list = append(list, ECSServiceType{Service: s})
+            }
+        }
+    }
+    return list, nil
+}
+
+
+// Return all running tasks in given cluster, with the EC2 instance each one runs on
+func GetECSTaskListFromAWS(svc *ecs.ECS, clusterArn *string) (list []ECSTaskType, err error) {
+    taskArns, err := GetECSArnsFromAWS(func(token *string) ([]*string, *string, error) {
+        resp, err := svc.ListTasks(&ecs.ListTasksInput{
+            Cluster:       clusterArn,
+            DesiredStatus: aws.String("RUNNING"),
+            MaxResults:    aws.Int64(100),  // 100 is AWS max request limit
+            NextToken:     token,
+        })
+        if err != nil {
+            return nil, nil, err
+        }
+        return resp.TaskArns, resp.NextToken, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    var containerInstArns []*string
+    for _, batch := range GetArnBatches(taskArns, 100) {
+        var resp *ecs.DescribeTasksOutput
+        err := CallWithRetry("ecs", func() (err error) {
+            resp, err = svc.DescribeTasks(&ecs.DescribeTasksInput{
+                Cluster: clusterArn,
+                Tasks:   batch,
+            })
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, t := range resp.Tasks {
+            if t == nil {
+                continue
+            }
+            list = append(list, ECSTaskType{Task: t})
+            if t.ContainerInstanceArn != nil &&
+               !strInList(*t.ContainerInstanceArn, aws.StringValueSlice(containerInstArns)) {
+                containerInstArns = append(containerInstArns, t.ContainerInstanceArn)
+            }
+        }
+    }
+
+    // Look up the EC2 instances behind the tasks' container instances
+    instIds := map[string]string{}
+    for _, batch := range GetArnBatches(containerInstArns, 100) {
+        var resp *ecs.DescribeContainerInstancesOutput
+        err := CallWithRetry("ecs", func() (err error) {
+            resp, err = svc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
+                Cluster:            clusterArn,
+                ContainerInstances: batch,
+            })
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, ci := range resp.ContainerInstances {
+            if ci != nil && ci.ContainerInstanceArn != nil && ci.Ec2InstanceId != nil {
+                instIds[*ci.ContainerInstanceArn] = *ci.Ec2InstanceId
+            }
+        }
+    }
+    for i := range list {
+        if instId, ok := instIds[aws.StringValue(list[i].ContainerInstanceArn)]; ok {
+            list[i].SetEc2InstanceId(instId)
+        }
+    }
+    return list, nil
+}
+
+
+// Return all ARNs from given ECS list call, following its next tokens and retrying as per
+// this service's retry policy
+func GetECSArnsFromAWS(list func(token *string) ([]*string, *string, error)) (arns []*string, err error) {
+    var token *string
+    for {
+        var batch []*string
+        var next *string
+        err := CallWithRetry("ecs", func() (err error) {
+            batch, next, err = list(token)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        arns = append(arns, batch...)
+        if next == nil {
+            break
+        }
+        token = next
+    }
+    return arns, nil
+}
+
+
+// Split given ARNs into batches of up to given size, for the describe calls
+func GetArnBatches(arns []*string, size int) (batches [][]*string) {
+    for len(arns) > size {
+        batches = append(batches, arns[:size])
+        arns = arns[size:]
+    }
+    if len(arns) > 0 {
+        batches = append(batches, arns)
+    }
+    return batches
+}
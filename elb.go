@@ -3,13 +3,11 @@ package main
 
 import (
     "fmt"
-    "time"
     "strings"
     "strconv"
     "errors"
     "encoding/json"
     "github.com/aws/aws-sdk-go/aws"
-    "github.com/aws/aws-sdk-go/aws/session"
     "github.com/aws/aws-sdk-go/service/elb"
     "github.com/aws/aws-sdk-go/aws/awsutil"
 )
@@ -18,9 +16,19 @@ import (
 type LoadBalancerDescriptionType struct {
     AccountAlias  *string
     AccountId     *string
+    Region        *string
     *elb.LoadBalancerDescription
 }
 
+// Register this resource kind with the stores
+var ELBKind = RegisterStoreKind("ELB", ELBDatafile)
+
+// Register classic, application and network load balancers with the DNS breakdown
+var ELBTarget = RegisterDNSTarget("ELB", IsLocalELB, func(dnsName string) []string {
+    BreakdownELB(dnsName)
+    return nil
+})
+
 // Return string representation of this type
 func (s LoadBalancerDescriptionType) String() string {
     return awsutil.Prettify(s)
@@ -38,12 +46,27 @@ func (s *LoadBalancerDescriptionType) SetAccountId(v string) *LoadBalancerDescri
     return s
 }
 
+// Set Region field's value
+func (s *LoadBalancerDescriptionType) SetRegion(v string) *LoadBalancerDescriptionType {
+    s.Region = &v
+    return s
+}
+
+// Query fields of ELB records, of all types
+var ELBFields = []string{"name", "dns", "count", "instances", "region", "type"}
+
+// Output fields of ELB health checks and certs, of all types
+var ELBHealthCheckFields = []string{"dns", "healthy", "unhealthy", "interval", "timeout", "target"}
+var ELBCertFields = []string{"dns", "cert", "expiry", "daysleft", "domains"}
+
 // Display all ELB records with applied filter
 func ListELBRecords(filter string) {
+    query := ParseQueryOrDie(filter, ELBFields)
     elbList, err := GetELBList()
     if err != nil {
         Die(1, err.Error())
     }
+    listing := NewListing(ELBFields)
     for _, elbRec := range elbList {
         elbName, elbDNSName, instCount, instIds := GetDetailsOfELB(elbRec)
         instances := ""   // Build instances strings
@@ -51,36 +74,28 @@ func ListELBRecords(filter string) {
             instances = instances + instIds[i] + " "
         }
         instances = strings.TrimSpace(instances)
-        if filter == "" || strContains(elbName, filter) ||
-                           strContains(elbDNSName, filter) ||
-                           strContains(instances, filter) {
-            fmt.Printf("%-36s  %-80s  %4d  %s\n", elbName, elbDNSName, instCount, instances)
+        region := "-"
+        if elbRec.Region != nil { region = *elbRec.Region }
+        fields := NewFieldList(ELBFields, elbName, elbDNSName, strconv.Itoa(instCount),
+            instances, region, "classic")
+        if query.Match(fields) {
+            listing.Add(fields, elbRec, func() {
+                fmt.Printf("%-36s  %-80s  %4d  %s\n", elbName, elbDNSName, instCount, instances)
+            })
         }
     }
+    // Application and network load balancers, if there's a store for them yet
+    if elbV2List, err := GetELBV2List(); err == nil {
+        ListELBV2Records(query, listing, elbV2List)
+    }
+    listing.Flush()
     return
 }
 
 
-// Return elb records list in local or remote store
+// Return ELB records list from the data store
 func GetELBList() (list []LoadBalancerDescriptionType, err error) {
-    localFileTimestamp := GetLocalFileTime(ELBDatafile)
-    remoteFileTimestamp := GetRemoteFileTime(ELBDatafile)
-
-    // Use remote S3 file if it's newer
-    if remoteFileTimestamp.After(localFileTimestamp) {
-        tmplist, err := GetListFromRemote(ELBDatafile)
-        list = tmplist.([]LoadBalancerDescriptionType)  // Assert our ELB type
-        if err == nil {
-            WriteList(list, ELBDatafile)          // Update local with this newer set
-            return list, nil
-        }        
-        // Return what must be an empty list with the error code
-        return list, err
-    }
-
-    // Else, just return local file content with error code
-    tmplist, err := GetListFromLocal(ELBDatafile)
-    list = tmplist.([]LoadBalancerDescriptionType)
+    err = DataStore.Load(ELBKind, &list)
     return list, err
 }
 
@@ -91,6 +106,7 @@ func ListELBHealthChecks(filter string) {
     if err != nil {
         Die(1, err.Error())
     }
+    listing := NewListing(ELBHealthCheckFields)
     for _, elbRec := range elbList {
         dns, healthy, unhealthy, interval, timeout, target := "-", "-", "-", "-", "-", "-"
         if elbRec.HealthCheck != nil { 
@@ -113,11 +129,19 @@ func ListELBHealthChecks(filter string) {
             }
             // Print only if qualified by filter
             if filter == "" || strContains(dns, filter) || strContains(target, filter) {
-                fmt.Printf("%-80s  %4s  %4s  %4s  %4s  %s\n",
-                    dns, healthy, unhealthy, interval, timeout, target)
+                fields := NewFieldList(ELBHealthCheckFields, dns, healthy, unhealthy, interval,
+                    timeout, target)
+                listing.Add(fields, elbRec, func() {
+                    fmt.Printf("%-80s  %4s  %4s  %4s  %4s  %s\n",
+                        dns, healthy, unhealthy, interval, timeout, target)
+                })
             }
         }
     }
+    if elbV2List, err := GetELBV2List(); err == nil {
+        ListELBV2HealthChecks(filter, listing, elbV2List)
+    }
+    listing.Flush()
 }
 
 
@@ -127,6 +151,8 @@ func ListELBCerts(filter string) {
     if err != nil {
         Die(1, err.Error())
     }
+    certList, _ := GetCertList()   // Fine if there's no cert store yet
+    listing := NewListing(ELBCertFields)
     for _, elbRec := range elbList {
         dns, cert := "-", "-"
         if elbRec.ListenerDescriptions != nil && len(elbRec.ListenerDescriptions) > 0 {
@@ -142,11 +168,20 @@ func ListELBCerts(filter string) {
         if elbRec.DNSName != nil {
             dns = *elbRec.DNSName
         }
+        expiry, daysLeft, domains := GetCertExpiryDetails(cert, certList)
         // Print only if qualified by filter
-        if filter == "" || strContains(dns, filter) || strContains(cert, filter) {
-            fmt.Printf("%-80s  %s\n", dns, cert)
+        if filter == "" || strContains(dns, filter) || strContains(cert, filter) ||
+                           strContains(domains, filter) {
+            fields := NewFieldList(ELBCertFields, dns, cert, expiry, daysLeft, domains)
+            listing.Add(fields, elbRec, func() {
+                fmt.Printf("%-80s  %-90s  %-10s  %5s  %s\n", dns, cert, expiry, daysLeft, domains)
+            })
         }
     }
+    if elbV2List, err := GetELBV2List(); err == nil {
+        ListELBV2Certs(filter, listing, elbV2List, certList)
+    }
+    listing.Flush()
 }
 
 
@@ -174,6 +209,10 @@ func BreakdownELB(elbDNSName string) {
     // Dont do anything if an ELB with that DNS name doesnt exist
     elb, err := GetELBFromLocal(elbDNSName)
     if err != nil {
+        // It may be an application or network load balancer instead
+        if elbV2, err := GetELBV2FromLocal(elbDNSName); err == nil {
+            BreakdownELBV2(elbV2)
+        }
         return
     }
 
@@ -208,43 +247,54 @@ func BreakdownELB(elbDNSName string) {
         fmt.Println("  No listeners defined")
     }
 
-    // Print instances
-    if elb.Instances != nil {
-        instCount := len(elb.Instances)
-        if instCount > 0 {
-            masterInstList, err := GetInstanceList()   // For efficiency, get instance store list here
-            if err != nil {
-                panic(err.Error())
-            }
-            for x := 0 ; x < instCount ; x++ {
-                inst := elb.Instances[x]
-                if inst != nil {
-                    if inst.InstanceId != nil {
-                        notfound := true
-                        for _, i := range masterInstList{
-                            if i.InstanceId != nil &&
-                               strings.EqualFold(*i.InstanceId, *inst.InstanceId) {
-                                a, b, c, d, e, f, _, _, _, _, _, _, _, _ := GetInstanceDetails(&i)
-                                // a = Name     b = InstanceId    c = InstanceType
-                                // d = State    e = IPAddr        f = AccountAlias
-                                fmt.Printf("    %-38s  %-20s  %-12s  %-10s  %-16s  %-16s\n",
-                                           a, b, c, d, e, f)
-                                notfound = false
-                                break
-                            }
-                        }
-                        if notfound {
-                            fmt.Printf("    %s not found in instance store\n", *inst.InstanceId)
-                        }
-                    }
-                }
+    // Print instances, grouped by the ASGs they belong to
+    _, _, instCount, instIds := GetDetailsOfELB(elb)
+    if instCount > 0 {
+        masterInstList, err := GetInstanceList()   // For efficiency, get instance store list here
+        if err != nil {
+            panic(err.Error())
+        }
+        asgList, _ := GetASGList()
+
+        // Name the ECS services running on these instances, if any
+        services := map[string]string{}
+        serviceList, _ := GetECSServiceList()
+        taskList, _ := GetECSTaskList()
+        for _, instId := range instIds {
+            if name := GetECSServiceNameOfTarget(instId, aws.StringValue(elb.LoadBalancerName), serviceList, taskList); name != "" {
+                services[instId] = name
             }
         }
+        BreakdownInstancesByASG("    ", instIds, nil, services, masterInstList, asgList)
     }
     return
 }
 
 
+// Return breakdown line of given instance ID from given instance list, and whether it was found
+func GetInstanceBreakdownLine(instId string, masterInstList []InstanceType) (string, bool) {
+    for _, i := range masterInstList {
+        if i.InstanceId != nil && strings.EqualFold(*i.InstanceId, instId) {
+            a, b, c, d, e, f, _, _, _, _, _, _, _, _ := GetInstanceDetails(&i)
+            // a = Name     b = InstanceId    c = InstanceType
+            // d = State    e = IPAddr        f = AccountAlias
+            return fmt.Sprintf("%-38s  %-20s  %-12s  %-10s  %-16s  %-16s", a, b, c, d, e, f), true
+        }
+    }
+    return "", false
+}
+
+
+// Check if given DNS name belongs to one of our classic, application or network load balancers
+func IsLocalELB(elbDNSName string) bool {
+    if _, err := GetELBFromLocal(elbDNSName); err == nil {
+        return true
+    }
+    _, err := GetELBV2FromLocal(elbDNSName)
+    return err == nil
+}
+
+
 // Return specific ELB record name, if it exists in local store
 func GetELBFromLocal(elbDNSName string) (LoadBalancerDescriptionType, error) {
     empty := LoadBalancerDescriptionType{}         // Empty record
@@ -263,70 +313,80 @@ func GetELBFromLocal(elbDNSName string) (LoadBalancerDescriptionType, error) {
 }
 
 
-// Update local copy of ELB records from current AWS account
-func UpdateLocalELBStoreFromAWS(minutesAgo int) {
+// Update local copy of ELB records from given AWS accounts
+func UpdateLocalELBStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("elasticloadbalancing", accounts, minutesAgo)
+
     // Do full update if minutesAgo is zero (meaning it wasn't specified)
     if minutesAgo == 0 {
         fmt.Printf("Updating local ELB store.\n")
     } else {
-        updatedELBCount := len(GetCloudTrailEvents("elasticloadbalancing", minutesAgo)) 
-        if updatedELBCount < 1 {
+        if len(sweeps) < 1 {
             // Skip ELB update if no ELB events within last minutesAgo
             fmt.Printf("Skipping local ELB store update (no mods within %d minutes)\n",
                 minutesAgo)
             return
         }
-        fmt.Printf("Updating local ELB store (%d modified within %d minutes)\n",
-            updatedELBCount, minutesAgo)
+        fmt.Printf("Updating local ELB store (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
     }
 
-    // Create a new list from existing store, without the ones for the current AWS account
+    // Get all records for each account and region concurrently
+    results := make([][]LoadBalancerDescriptionType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("elasticloadbalancing", len(sweeps), func(i int) {
+        results[i], errs[i] = GetELBListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(ELBKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts and regions
     var list []LoadBalancerDescriptionType
     elbList, _ := GetELBList()
     for _, elb := range elbList {
-        if *elb.AccountId != AWSAccountId {
+        if !RecordInSweep(elb.AccountId, elb.Region, updated) {
             list = append(list, elb)
         }
     }
 
-    // Now get all records for this account, and add them to this new list
-    for _, elb := range GetELBListFromAWS() {
-        list = append(list, elb)
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
     }
 
     // Make this the new local list
-    WriteList(list, ELBDatafile)
+    WriteList(list, ELBKind)
     return
 }
 
 
-// Return all ELB objects in current AWS account
-func GetELBListFromAWS() (list []LoadBalancerDescriptionType) {
-    SetAWSRegion()
-    sess := session.Must(session.NewSession())
-    svc := elb.New(sess, aws.NewConfig().WithRegion(AWSRegion))
+// Return all ELB objects in given account and region
+func GetELBListFromAWS(acct *AccountType, region string) (list []LoadBalancerDescriptionType, err error) {
+    svc := elb.New(acct.Sess, AWSConfig(region))
 
     params := &elb.DescribeLoadBalancersInput{
         PageSize: aws.Int64(400),  // 400 is AWS max request limit
     }
-    // Loop requests in case there're more than PageSize records or we're being throttled
-    errcount := 0
+    // Loop requests in case there're more than PageSize records
     for {
-        // Get batch of records
-        resp, err := svc.DescribeLoadBalancers(params)
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *elb.DescribeLoadBalancersOutput
+        err := CallWithRetry("elasticloadbalancing", func() (err error) {
+            resp, err = svc.DescribeLoadBalancers(params)
+            return err
+        })
         if err != nil {
-            // Sleep for a moment if AWS is throttling us
-            if BeingThrottled(err) {
-                fmt.Printf("  AWS throttling. Sleeping %d seconds...\n", APISecondsDelay)
-                time.Sleep(time.Duration(APISecondsDelay) * time.Second)
-                continue
-            }
-            // Allow for 3 other unknown API call errors before panicking
-            if errcount < 3 {
-                errcount++
-                continue
-            }
-            panic(err.Error())   // Abort on any other error
+            return nil, err
         }
 
         // Ensure valid data came back
@@ -335,19 +395,20 @@ func GetELBListFromAWS() (list []LoadBalancerDescriptionType) {
             // First convert it to raw []byte
             jsonData, err := json.Marshal(resp.LoadBalancerDescriptions)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Now read it into extended type list
             var elbList []LoadBalancerDescriptionType
             err = json.Unmarshal(jsonData, &elbList)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Add this batch to our list
             for _, elb := range elbList {
                 // Add our additional fields
-                elb = *elb.SetAccountAlias(AWSAccountAlias)
-                elb = *elb.SetAccountId(AWSAccountId)
+                elb = *elb.SetAccountAlias(acct.Alias)
+                elb = *elb.SetAccountId(acct.Id)
+                elb = *elb.SetRegion(region)
                 list = append(list, elb)
             }
         }
@@ -359,5 +420,5 @@ func GetELBListFromAWS() (list []LoadBalancerDescriptionType) {
             params.Marker = resp.NextMarker
         }
     }
-    return list
+    return list, nil
 }
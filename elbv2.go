@@ -0,0 +1,665 @@
+// elbv2.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "errors"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/elbv2"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS elbv2.LoadBalancer type to include these additional fields
+type LoadBalancerV2Type struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    Listeners     []*ListenerV2Type
+    TargetGroups  []*TargetGroupV2Type   // Target groups this load balancer forwards to
+    *elbv2.LoadBalancer
+}
+
+// Extend AWS elbv2.Listener type to include its rules
+type ListenerV2Type struct {
+    Rules  []*elbv2.Rule   // Only application load balancers have rules
+    *elbv2.Listener
+}
+
+// Extend AWS elbv2.TargetGroup type to include its registered targets and their health
+type TargetGroupV2Type struct {
+    Targets  []*elbv2.TargetHealthDescription
+    *elbv2.TargetGroup
+}
+
+// Register this resource kind with the stores
+var ELBV2Kind = RegisterStoreKind("ELBv2", ELBV2DataFile)
+
+// Return string representation of this type
+func (s LoadBalancerV2Type) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *LoadBalancerV2Type) SetAccountAlias(v string) *LoadBalancerV2Type {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *LoadBalancerV2Type) SetAccountId(v string) *LoadBalancerV2Type {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *LoadBalancerV2Type) SetRegion(v string) *LoadBalancerV2Type {
+    s.Region = &v
+    return s
+}
+
+
+// Return ELBv2 records list from the data store
+func GetELBV2List() (list []LoadBalancerV2Type, err error) {
+    err = DataStore.Load(ELBV2Kind, &list)
+    return list, err
+}
+
+
+// Add all ELBv2 records matching given query to given listing of classic ELBs
+func ListELBV2Records(query *QueryType, listing *ListingType, elbList []LoadBalancerV2Type) {
+    for _, elbRec := range elbList {
+        elbName, elbDNSName, targetCount, targetIds := GetDetailsOfELBV2(elbRec)
+        targets := strings.Join(targetIds, " ")
+        region, elbType := "-", "-"
+        if elbRec.Region != nil { region = *elbRec.Region }
+        if elbRec.Type != nil { elbType = *elbRec.Type }
+        fields := NewFieldList(ELBFields, elbName, elbDNSName, strconv.Itoa(targetCount),
+            targets, region, elbType)
+        if query.Match(fields) {
+            listing.Add(fields, elbRec, func() {
+                fmt.Printf("%-36s  %-80s  %4d  %s\n", elbName, elbDNSName, targetCount, targets)
+            })
+        }
+    }
+}
+
+
+// Add all ELBv2 target group health checks with applied filter to given listing
+func ListELBV2HealthChecks(filter string, listing *ListingType, elbList []LoadBalancerV2Type) {
+    for _, elbRec := range elbList {
+        dns := "-"
+        if elbRec.DNSName != nil { dns = *elbRec.DNSName }
+        for _, tg := range elbRec.TargetGroups {
+            if tg == nil || tg.TargetGroup == nil {
+                continue
+            }
+            healthy, unhealthy, interval, timeout := "-", "-", "-", "-"
+            if tg.HealthyThresholdCount != nil {
+                healthy = strconv.FormatInt(*tg.HealthyThresholdCount, 10)
+            }
+            if tg.UnhealthyThresholdCount != nil {
+                unhealthy = strconv.FormatInt(*tg.UnhealthyThresholdCount, 10)
+            }
+            if tg.HealthCheckIntervalSeconds != nil {
+                interval = strconv.FormatInt(*tg.HealthCheckIntervalSeconds, 10)
+            }
+            if tg.HealthCheckTimeoutSeconds != nil {
+                timeout = strconv.FormatInt(*tg.HealthCheckTimeoutSeconds, 10)
+            }
+            target := GetHealthCheckTargetOfELBV2(tg)
+            // Print only if qualified by filter
+            if filter == "" || strContains(dns, filter) || strContains(target, filter) {
+                fields := NewFieldList(ELBHealthCheckFields, dns, healthy, unhealthy, interval,
+                    timeout, target)
+                listing.Add(fields, tg, func() {
+                    fmt.Printf("%-80s  %4s  %4s  %4s  %4s  %s\n",
+                        dns, healthy, unhealthy, interval, timeout, target)
+                })
+            }
+        }
+    }
+}
+
+
+// Add all ELBv2 listener certs with applied filter to given listing
+func ListELBV2Certs(filter string, listing *ListingType, elbList []LoadBalancerV2Type,
+                    certList []CertificateType) {
+    for _, elbRec := range elbList {
+        dns := "-"
+        if elbRec.DNSName != nil { dns = *elbRec.DNSName }
+        var certs []string
+        for _, l := range elbRec.Listeners {
+            if l == nil || l.Listener == nil {
+                continue
+            }
+            for _, c := range l.Certificates {
+                if c != nil && c.CertificateArn != nil && !strInList(*c.CertificateArn, certs) {
+                    certs = append(certs, *c.CertificateArn)
+                }
+            }
+        }
+        if len(certs) == 0 {
+            certs = append(certs, "-")
+        }
+        // Print only if qualified by filter, one line per cert
+        for _, cert := range certs {
+            cert := cert   // For the closure below
+            expiry, daysLeft, domains := GetCertExpiryDetails(cert, certList)
+            if filter == "" || strContains(dns, filter) || strContains(cert, filter) ||
+                               strContains(domains, filter) {
+                fields := NewFieldList(ELBCertFields, dns, cert, expiry, daysLeft, domains)
+                listing.Add(fields, elbRec, func() {
+                    fmt.Printf("%-80s  %-90s  %-10s  %5s  %s\n", dns, cert, expiry, daysLeft, domains)
+                })
+            }
+        }
+    }
+}
+
+
+// Return important attributes of given object. Targets are counted once, however many of
+// the load balancer's target groups they're registered in
+func GetDetailsOfELBV2(elbRec LoadBalancerV2Type) (elbName, elbDNSName string,
+                                                   targetCount int, targetIds []string) {
+    elbName, elbDNSName, targetCount, targetIds = "-", "-", 0, nil
+
+    if elbRec.LoadBalancerName != nil { elbName = *elbRec.LoadBalancerName }
+    if elbRec.DNSName != nil { elbDNSName = *elbRec.DNSName }
+
+    for _, tg := range elbRec.TargetGroups {
+        if tg == nil {
+            continue
+        }
+        for _, t := range tg.Targets {
+            if t != nil && t.Target != nil && t.Target.Id != nil && !strInList(*t.Target.Id, targetIds) {
+                targetIds = append(targetIds, *t.Target.Id)
+            }
+        }
+    }
+    targetCount = len(targetIds)
+    return
+}
+
+
+// Return health check target of given target group, in the classic ELB PROTOCOL:PORT/PATH format
+func GetHealthCheckTargetOfELBV2(tg *TargetGroupV2Type) string {
+    protocol, port, path := "-", "-", ""
+    if tg.HealthCheckProtocol != nil { protocol = *tg.HealthCheckProtocol }
+    if tg.HealthCheckPort != nil { port = *tg.HealthCheckPort }
+    if tg.HealthCheckPath != nil && strings.HasPrefix(protocol, "HTTP") {
+        path = *tg.HealthCheckPath
+    }
+    return protocol + ":" + port + path
+}
+
+
+// Breakdown given ELBv2 record into its listeners, rules, target groups and targets
+func BreakdownELBV2(elb LoadBalancerV2Type) {
+    elbDNSName := "-"
+    if elb.DNSName != nil { elbDNSName = *elb.DNSName }
+    fmt.Println(elbDNSName)
+
+    if len(elb.Listeners) == 0 {
+        fmt.Println("  No listeners defined")
+        return
+    }
+
+    masterInstList, _ := GetInstanceList()   // For efficiency, get instance store list here
+
+    for _, l := range elb.Listeners {
+        if l == nil || l.Listener == nil {
+            continue
+        }
+        protocol, port := "-", "-"
+        if l.Protocol != nil { protocol = *l.Protocol }
+        if l.Port != nil { port = strconv.FormatInt(*l.Port, 10) }
+        fmt.Printf("  %s:%s\n", protocol, port)
+
+        // Network load balancers have no rules, only the listener's default actions
+        if len(l.Rules) == 0 {
+            BreakdownELBV2Actions("default", l.DefaultActions, elb, masterInstList)
+            continue
+        }
+        for _, rule := range l.Rules {
+            if rule != nil {
+                BreakdownELBV2Actions(GetRuleDescription(rule), rule.Actions, elb, masterInstList)
+            }
+        }
+    }
+    return
+}
+
+
+// Print given rule's actions, and the targets of any target groups they forward to
+func BreakdownELBV2Actions(ruleDesc string, actions []*elbv2.Action, elb LoadBalancerV2Type,
+                           masterInstList []InstanceType) {
+    for _, action := range actions {
+        if action == nil || action.Type == nil {
+            continue
+        }
+        switch *action.Type {
+        case "forward":
+            fmt.Printf("    %s -> forward\n", ruleDesc)
+            var tgArns []string
+            if action.ForwardConfig != nil {
+                for _, tuple := range action.ForwardConfig.TargetGroups {
+                    if tuple != nil && tuple.TargetGroupArn != nil {
+                        tgArns = append(tgArns, *tuple.TargetGroupArn)
+                    }
+                }
+            }
+            if len(tgArns) == 0 && action.TargetGroupArn != nil {
+                tgArns = append(tgArns, *action.TargetGroupArn)
+            }
+            for _, tgArn := range tgArns {
+                BreakdownTargetGroupV2(tgArn, elb, masterInstList)
+            }
+        case "redirect":
+            target := "-"
+            if rc := action.RedirectConfig; rc != nil {
+                target = strings.ToLower(aws.StringValue(rc.Protocol)) + "://" +
+                         aws.StringValue(rc.Host) + ":" + aws.StringValue(rc.Port) +
+                         aws.StringValue(rc.Path)
+            }
+            fmt.Printf("    %s -> redirect %s\n", ruleDesc, target)
+        case "fixed-response":
+            status := "-"
+            if action.FixedResponseConfig != nil && action.FixedResponseConfig.StatusCode != nil {
+                status = *action.FixedResponseConfig.StatusCode
+            }
+            fmt.Printf("    %s -> fixed-response %s\n", ruleDesc, status)
+        default:
+            fmt.Printf("    %s -> %s\n", ruleDesc, *action.Type)
+        }
+    }
+}
+
+
+// Print given target group of given ELBv2 record and its targets, resolving instances
+// thru the instance store
+func BreakdownTargetGroupV2(tgArn string, elb LoadBalancerV2Type, masterInstList []InstanceType) {
+    var tg *TargetGroupV2Type
+    for _, t := range elb.TargetGroups {
+        if t != nil && t.TargetGroupArn != nil && *t.TargetGroupArn == tgArn {
+            tg = t
+            break
+        }
+    }
+    if tg == nil {
+        fmt.Printf("      %s not found in ELBv2 store\n", tgArn)
+        return
+    }
+
+    tgName, protocol, port, targetType := "-", "-", "-", "instance"
+    if tg.TargetGroupName != nil { tgName = *tg.TargetGroupName }
+    if tg.Protocol != nil { protocol = *tg.Protocol }
+    if tg.Port != nil { port = strconv.FormatInt(*tg.Port, 10) }
+    if tg.TargetType != nil { targetType = *tg.TargetType }
+    fmt.Printf("      %s  %s:%s  %s\n", tgName, protocol, port, targetType)
+
+    if len(tg.Targets) == 0 {
+        fmt.Println("        No targets registered")
+        return
+    }
+
+    serviceList, _ := GetECSServiceList()
+    taskList, _ := GetECSTaskList()
+
+    // Instance targets are grouped by the ASGs they belong to
+    if targetType == "instance" {
+        var instIds []string
+        health := map[string]string{}
+        services := map[string]string{}
+        for _, t := range tg.Targets {
+            if t == nil || t.Target == nil || t.Target.Id == nil {
+                continue
+            }
+            instIds = append(instIds, *t.Target.Id)
+            health[*t.Target.Id] = "-"
+            if t.TargetHealth != nil && t.TargetHealth.State != nil {
+                health[*t.Target.Id] = *t.TargetHealth.State
+            }
+            // Name the ECS service running on this target, if any
+            if name := GetECSServiceNameOfTarget(*t.Target.Id, tgArn, serviceList, taskList); name != "" {
+                services[*t.Target.Id] = name
+            }
+        }
+        asgList, _ := GetASGList()
+        BreakdownInstancesByASG("        ", instIds, health, services, masterInstList, asgList)
+        return
+    }
+
+    lambdaList, _ := GetLambdaList()
+    for _, t := range tg.Targets {
+        if t == nil || t.Target == nil || t.Target.Id == nil {
+            continue
+        }
+        health := "-"
+        if t.TargetHealth != nil && t.TargetHealth.State != nil {
+            health = *t.TargetHealth.State
+        }
+        targetId := *t.Target.Id
+        switch targetType {
+        case "ip":
+            if t.Target.Port != nil {
+                targetId += ":" + strconv.FormatInt(*t.Target.Port, 10)
+            }
+            if name := GetECSServiceNameOfTarget(*t.Target.Id, tgArn, serviceList, taskList); name != "" {
+                health += "  ecs:" + name
+            }
+            fmt.Printf("        %-38s  %s\n", targetId, health)
+        case "lambda":
+            if fn := GetLambdaByArn(targetId, lambdaList); fn != nil {
+                a, b, c, _, _, f, _, _, _ := GetDetailsOfLambda(*fn)
+                // a = Name    b = Runtime    c = Memory    f = AccountAlias
+                fmt.Printf("        %-38s  %-14s  %5s  %-16s  %s\n", a, b, c, f, health)
+            } else {
+                fmt.Printf("        %s not found in Lambda store  %s\n", targetId, health)
+            }
+        default:
+            // Load balancers are listed by ARN
+            fmt.Printf("        %s  %s\n", targetId, health)
+        }
+    }
+}
+
+
+// Return short description of given rule's conditions, e.g. '[10] host-header=a.com path-pattern=/api/*'
+func GetRuleDescription(rule *elbv2.Rule) string {
+    if rule.IsDefault != nil && *rule.IsDefault {
+        return "default"
+    }
+    var conds []string
+    for _, c := range rule.Conditions {
+        if c == nil || c.Field == nil {
+            continue
+        }
+        var values []*string
+        if c.HostHeaderConfig != nil {
+            values = c.HostHeaderConfig.Values
+        } else if c.PathPatternConfig != nil {
+            values = c.PathPatternConfig.Values
+        } else {
+            values = c.Values
+        }
+        conds = append(conds, *c.Field + "=" + strings.Join(aws.StringValueSlice(values), ","))
+    }
+    priority := "-"
+    if rule.Priority != nil { priority = *rule.Priority }
+    return "[" + priority + "] " + strings.Join(conds, " ")
+}
+
+
+// Return specific ELBv2 record, if it exists in local store
+func GetELBV2FromLocal(elbDNSName string) (LoadBalancerV2Type, error) {
+    empty := LoadBalancerV2Type{}                  // Empty record
+    elbList, err := GetELBV2List()
+    if err != nil {
+        return empty, err
+    }
+    for _, elb := range elbList {
+        if elb.DNSName != nil {
+            if strings.EqualFold(NormalDNSName(elbDNSName), NormalDNSName(*elb.DNSName)) {
+                return elb, nil
+            }
+        }
+    }
+    return empty, errors.New("Record not found.")  // Return empty record
+}
+
+
+// Update local copy of ELBv2 records from given AWS accounts
+func UpdateLocalELBV2StoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("elasticloadbalancing", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local ELBv2 store.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip ELBv2 update if no ELB events within last minutesAgo
+            fmt.Printf("Skipping local ELBv2 store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local ELBv2 store (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    results := make([][]LoadBalancerV2Type, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("elasticloadbalancing", len(sweeps), func(i int) {
+        results[i], errs[i] = GetELBV2ListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(ELBV2Kind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts and regions
+    var list []LoadBalancerV2Type
+    elbList, _ := GetELBV2List()
+    for _, elb := range elbList {
+        if !RecordInSweep(elb.AccountId, elb.Region, updated) {
+            list = append(list, elb)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, ELBV2Kind)
+    return
+}
+
+
+// Return all ELBv2 objects in given account and region, with their listeners, rules and
+// target groups
+func GetELBV2ListFromAWS(acct *AccountType, region string) (list []LoadBalancerV2Type, err error) {
+    svc := elbv2.New(acct.Sess, AWSConfig(region))
+
+    // Get all target groups in the region first, so each load balancer can pick its own
+    tgList, err := GetTargetGroupV2ListFromAWS(svc)
+    if err != nil {
+        return nil, err
+    }
+
+    params := &elbv2.DescribeLoadBalancersInput{
+        PageSize: aws.Int64(400),  // 400 is AWS max request limit
+    }
+    // Loop requests in case there're more than PageSize records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *elbv2.DescribeLoadBalancersOutput
+        err := CallWithRetry("elasticloadbalancing", func() (err error) {
+            resp, err = svc.DescribeLoadBalancers(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        for _, lb := range resp.LoadBalancers {
+            if lb == nil || lb.LoadBalancerArn == nil {
+                continue
+            }
+            elb := LoadBalancerV2Type{LoadBalancer: lb}
+            listeners, err := GetListenerV2ListFromAWS(svc, lb)
+            if err != nil {
+                return nil, err
+            }
+            elb.Listeners = listeners
+            for _, tg := range tgList {
+                if strInList(*lb.LoadBalancerArn, aws.StringValueSlice(tg.LoadBalancerArns)) {
+                    elb.TargetGroups = append(elb.TargetGroups, tg)
+                }
+            }
+            // Add our additional fields
+            elb = *elb.SetAccountAlias(acct.Alias)
+            elb = *elb.SetAccountId(acct.Id)
+            elb = *elb.SetRegion(region)
+            list = append(list, elb)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextMarker == nil {
+            break
+        } else {
+            params.Marker = resp.NextMarker
+        }
+    }
+    return list, nil
+}
+
+
+// Return all listeners of given load balancer, with their rules
+func GetListenerV2ListFromAWS(svc *elbv2.ELBV2, lb *elbv2.LoadBalancer) (list []*ListenerV2Type, err error) {
+    params := &elbv2.DescribeListenersInput{
+        LoadBalancerArn: lb.LoadBalancerArn,
+        PageSize:        aws.Int64(400),
+    }
+    for {
+        var resp *elbv2.DescribeListenersOutput
+        err := CallWithRetry("elasticloadbalancing", func() (err error) {
+            resp, err = svc.DescribeListeners(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, l := range resp.Listeners {
+            if l == nil {
+                continue
+            }
+            listener := &ListenerV2Type{Listener: l}
+            // The listener only comes with its default cert, so get its SNI certs too
+            if l.ListenerArn != nil && l.Protocol != nil && (*l.Protocol == "HTTPS" || *l.Protocol == "TLS") {
+                l.Certificates, err = GetListenerCertificateListFromAWS(svc, l.ListenerArn)
+                if err != nil {
+                    return nil, err
+                }
+            }
+            // Only application load balancers have rules
+            if lb.Type != nil && *lb.Type == "application" && l.ListenerArn != nil {
+                listener.Rules, err = GetRuleV2ListFromAWS(svc, l.ListenerArn)
+                if err != nil {
+                    return nil, err
+                }
+            }
+            list = append(list, listener)
+        }
+        if resp.NextMarker == nil {
+            break
+        } else {
+            params.Marker = resp.NextMarker
+        }
+    }
+    return list, nil
+}
+
+
+// Return all certs of given HTTPS or TLS listener, the default one and those added for SNI
+func GetListenerCertificateListFromAWS(svc *elbv2.ELBV2, listenerArn *string) (list []*elbv2.Certificate,
+                                                                               err error) {
+    params := &elbv2.DescribeListenerCertificatesInput{
+        ListenerArn: listenerArn,
+        PageSize:    aws.Int64(400),
+    }
+    for {
+        var resp *elbv2.DescribeListenerCertificatesOutput
+        err := CallWithRetry("elasticloadbalancing", func() (err error) {
+            resp, err = svc.DescribeListenerCertificates(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, resp.Certificates...)
+        if resp.NextMarker == nil {
+            break
+        } else {
+            params.Marker = resp.NextMarker
+        }
+    }
+    return list, nil
+}
+
+
+// Return all rules of given listener
+func GetRuleV2ListFromAWS(svc *elbv2.ELBV2, listenerArn *string) (list []*elbv2.Rule, err error) {
+    params := &elbv2.DescribeRulesInput{
+        ListenerArn: listenerArn,
+        PageSize:    aws.Int64(400),
+    }
+    for {
+        var resp *elbv2.DescribeRulesOutput
+        err := CallWithRetry("elasticloadbalancing", func() (err error) {
+            resp, err = svc.DescribeRules(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        list = append(list, resp.Rules...)
+        if resp.NextMarker == nil {
+            break
+        } else {
+            params.Marker = resp.NextMarker
+        }
+    }
+    return list, nil
+}
+
+
+// Return all target groups in the service's region, with their registered targets and their health
+func GetTargetGroupV2ListFromAWS(svc *elbv2.ELBV2) (list []*TargetGroupV2Type, err error) {
+    params := &elbv2.DescribeTargetGroupsInput{
+        PageSize: aws.Int64(400),
+    }
+    for {
+        var resp *elbv2.DescribeTargetGroupsOutput
+        err := CallWithRetry("elasticloadbalancing", func() (err error) {
+            resp, err = svc.DescribeTargetGroups(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, tg := range resp.TargetGroups {
+            if tg == nil || tg.TargetGroupArn == nil {
+                continue
+            }
+            var resp2 *elbv2.DescribeTargetHealthOutput
+            err := CallWithRetry("elasticloadbalancing", func() (err error) {
+                resp2, err = svc.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+                    TargetGroupArn: tg.TargetGroupArn,
+                })
+                return err
+            })
+            if err != nil {
+                return nil, err
+            }
+            list = append(list, &TargetGroupV2Type{Targets: resp2.TargetHealthDescriptions, TargetGroup: tg})
+        }
+        if resp.NextMarker == nil {
+            break
+        } else {
+            params.Marker = resp.NextMarker
+        }
+    }
+    return list, nil
+}
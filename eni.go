@@ -0,0 +1,413 @@
+// eni.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/ec2"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS ec2.Address type to include these additional fields
+type AddressType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.Address
+}
+
+// Extend AWS ec2.NetworkInterface type to include these additional fields
+type NetworkInterfaceType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.NetworkInterface
+}
+
+// Register these resource kinds with the stores
+var (
+    AddressKind          = RegisterStoreKind("Elastic IP", AddressDataFile)
+    NetworkInterfaceKind = RegisterStoreKind("Network interface", ENIDataFile)
+)
+
+// Return string representation of this type
+func (s AddressType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *AddressType) SetAccountAlias(v string) *AddressType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *AddressType) SetAccountId(v string) *AddressType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *AddressType) SetRegion(v string) *AddressType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s NetworkInterfaceType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *NetworkInterfaceType) SetAccountAlias(v string) *NetworkInterfaceType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *NetworkInterfaceType) SetAccountId(v string) *NetworkInterfaceType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *NetworkInterfaceType) SetRegion(v string) *NetworkInterfaceType {
+    s.Region = &v
+    return s
+}
+
+
+// Display every resource in the stores that owns given IP address, i.e., instances, network
+// interfaces, Elastic IPs, and the ELBs behind any matching network interfaces
+func ListIPOwners(ip string) {
+    ip = strings.TrimSpace(ip)
+    found := 0
+    show := func(kind, id, detail string, acctAlias, region *string) {
+        found++
+        fmt.Printf("%-10s  %-26s  %-60s  %-18s  %s\n", kind, id, detail,
+            GetStringOrDash(acctAlias), GetStringOrDash(region))
+    }
+
+    instList, _ := GetInstanceList()
+    for _, inst := range instList {
+        if inst.Instance != nil && strInList(ip, GetInstanceIPs(inst)) {
+            a, b, _, d, _, _, _, _, _, _, _, _, _, _ := GetInstanceDetails(&inst)
+            // a = Name    b = InstanceId    d = State
+            show("instance", b, a + "  " + d, inst.AccountAlias, inst.Region)
+        }
+    }
+
+    addrList, _ := GetAddressList()
+    for _, addr := range addrList {
+        if addr.Address != nil && (aws.StringValue(addr.PublicIp) == ip ||
+                                    aws.StringValue(addr.PrivateIpAddress) == ip) {
+            a, b, c := GetDetailsOfAddress(addr)
+            show("eip", a, b + "  " + c, addr.AccountAlias, addr.Region)
+        }
+    }
+
+    elbList, _ := GetELBList()
+    elbV2List, _ := GetELBV2List()
+    eniList, _ := GetNetworkInterfaceList()
+    for _, eni := range eniList {
+        if eni.NetworkInterface == nil || !strInList(ip, GetNetworkInterfaceIPs(eni)) {
+            continue
+        }
+        a, b, c := GetDetailsOfNetworkInterface(eni)
+        show("eni", a, b + "  " + c, eni.AccountAlias, eni.Region)
+
+        // Load balancer interfaces are described as 'ELB NAME' or 'ELB app/NAME/ID'
+        desc := aws.StringValue(eni.Description)
+        if !strings.HasPrefix(desc, "ELB ") {
+            continue
+        }
+        lbId := strings.TrimPrefix(desc, "ELB ")
+        for _, elb := range elbList {
+            if aws.StringValue(elb.LoadBalancerName) == lbId &&
+               aws.StringValue(elb.AccountId) == aws.StringValue(eni.AccountId) {
+                name, dnsName, _, _ := GetDetailsOfELB(elb)
+                show("elb", name, dnsName, elb.AccountAlias, elb.Region)
+            }
+        }
+        for _, elb := range elbV2List {
+            if strings.HasSuffix(aws.StringValue(elb.LoadBalancerArn), "/" + lbId) {
+                name, dnsName, _, _ := GetDetailsOfELBV2(elb)
+                show("elbv2", name, dnsName, elb.AccountAlias, elb.Region)
+            }
+        }
+    }
+
+    if found == 0 {
+        fmt.Printf("%s not found in any store\n", ip)
+    }
+}
+
+
+// Return given string pointer's value, or '-' if it's nil or empty
+func GetStringOrDash(s *string) string {
+    if s == nil || *s == "" {
+        return "-"
+    }
+    return *s
+}
+
+
+// Return Elastic IP records list from the data store
+func GetAddressList() (list []AddressType, err error) {
+    err = DataStore.Load(AddressKind, &list)
+    return list, err
+}
+
+
+// Return network interface records list from the data store
+func GetNetworkInterfaceList() (list []NetworkInterfaceType, err error) {
+    err = DataStore.Load(NetworkInterfaceKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object. It's associated with either an instance or a
+// network interface, if anything
+func GetDetailsOfAddress(addr AddressType) (publicIp, allocId, association string) {
+    publicIp, allocId, association = "-", "-", "unassociated"
+
+    if addr.PublicIp != nil { publicIp = *addr.PublicIp }
+    if addr.AllocationId != nil { allocId = *addr.AllocationId }
+    if addr.InstanceId != nil {
+        association = *addr.InstanceId
+    } else if addr.NetworkInterfaceId != nil {
+        association = *addr.NetworkInterfaceId
+    }
+    return
+}
+
+
+// Return important attributes of given object. Interfaces not attached to an instance are
+// shown by their description, or by who created them if they don't have one
+func GetDetailsOfNetworkInterface(eni NetworkInterfaceType) (eniId, interfaceType, owner string) {
+    eniId, interfaceType, owner = "-", "interface", "-"
+
+    if eni.NetworkInterfaceId != nil { eniId = *eni.NetworkInterfaceId }
+    if eni.InterfaceType != nil { interfaceType = *eni.InterfaceType }
+    if eni.Attachment != nil && eni.Attachment.InstanceId != nil {
+        owner = *eni.Attachment.InstanceId
+    } else if eni.Description != nil && *eni.Description != "" {
+        owner = *eni.Description
+    } else if eni.RequesterId != nil {
+        owner = "requester:" + *eni.RequesterId
+    }
+    return
+}
+
+
+// Return all private and public IP addresses of given instance, including those of all its
+// network interfaces
+func GetInstanceIPs(inst InstanceType) (ips []string) {
+    add := func(ip *string) {
+        if ip != nil && *ip != "" && !strInList(*ip, ips) {
+            ips = append(ips, *ip)
+        }
+    }
+    add(inst.PrivateIpAddress)
+    add(inst.PublicIpAddress)
+    for _, nic := range inst.NetworkInterfaces {
+        if nic == nil {
+            continue
+        }
+        add(nic.PrivateIpAddress)
+        if nic.Association != nil { add(nic.Association.PublicIp) }
+        for _, p := range nic.PrivateIpAddresses {
+            if p == nil {
+                continue
+            }
+            add(p.PrivateIpAddress)
+            if p.Association != nil { add(p.Association.PublicIp) }
+        }
+    }
+    return ips
+}
+
+
+// Return all private, public and IPv6 addresses of given network interface
+func GetNetworkInterfaceIPs(eni NetworkInterfaceType) (ips []string) {
+    add := func(ip *string) {
+        if ip != nil && *ip != "" && !strInList(*ip, ips) {
+            ips = append(ips, *ip)
+        }
+    }
+    add(eni.PrivateIpAddress)
+    if eni.Association != nil { add(eni.Association.PublicIp) }
+    for _, p := range eni.PrivateIpAddresses {
+        if p == nil {
+            continue
+        }
+        add(p.PrivateIpAddress)
+        if p.Association != nil { add(p.Association.PublicIp) }
+    }
+    for _, p := range eni.Ipv6Addresses {
+        if p != nil { add(p.Ipv6Address) }
+    }
+    return ips
+}
+
+
+// Update local Elastic IP and network interface stores from given AWS accounts
+func UpdateLocalENIStoresFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("ec2", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local Elastic IP and network interface stores.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no EC2 events within last minutesAgo
+            fmt.Printf("Skipping local Elastic IP and network interface stores update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local Elastic IP and network interface stores (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    addrResults := make([][]AddressType, len(sweeps))
+    addrErrs := make([]error, len(sweeps))
+    eniResults := make([][]NetworkInterfaceType, len(sweeps))
+    eniErrs := make([]error, len(sweeps))
+    RunParallel("ec2", len(sweeps), func(i int) {
+        acct, region := sweeps[i].Account, sweeps[i].Region
+        addrResults[i], addrErrs[i] = GetAddressListFromAWS(acct, region)
+        eniResults[i], eniErrs[i] = GetNetworkInterfaceListFromAWS(acct, region)
+    })
+
+    // Keep previous Elastic IP records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(AddressKind.Name, sweep.Account, sweep.Region, addrErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        // Create a new list from existing store, without the ones for the updated accounts and regions
+        var list []AddressType
+        addrList, _ := GetAddressList()
+        for _, addr := range addrList {
+            if !RecordInSweep(addr.AccountId, addr.Region, updated) {
+                list = append(list, addr)
+            }
+        }
+        // Now add all the new records to this new list, and make it the new local list
+        for _, result := range addrResults {
+            list = append(list, result...)
+        }
+        WriteList(list, AddressKind)
+    }
+
+    // Same again for the network interfaces
+    updated = nil
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(NetworkInterfaceKind.Name, sweep.Account, sweep.Region, eniErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        var list []NetworkInterfaceType
+        eniList, _ := GetNetworkInterfaceList()
+        for _, eni := range eniList {
+            if !RecordInSweep(eni.AccountId, eni.Region, updated) {
+                list = append(list, eni)
+            }
+        }
+        for _, result := range eniResults {
+            list = append(list, result...)
+        }
+        WriteList(list, NetworkInterfaceKind)
+    }
+    return
+}
+
+
+// Return all Elastic IP objects in given account and region
+func GetAddressListFromAWS(acct *AccountType, region string) (list []AddressType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    // There's no paging, all addresses come back at once
+    var resp *ec2.DescribeAddressesOutput
+    err = CallWithRetry("ec2", func() (err error) {
+        resp, err = svc.DescribeAddresses(&ec2.DescribeAddressesInput{})
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    for _, a := range resp.Addresses {
+        if a == nil {
+            continue
+        }
+        addr := AddressType{Address: a}
+        // Add our additional fields
+        addr = *addr.SetAccountAlias(acct.Alias)
+        addr = *addr.SetAccountId(acct.Id)
+        addr = *addr.SetRegion(region)
+        list = append(list, addr)
+    }
+    return list, nil
+}
+
+
+// Return all network interface objects in given account and region
+func GetNetworkInterfaceListFromAWS(acct *AccountType, region string) (list []NetworkInterfaceType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    params := &ec2.DescribeNetworkInterfacesInput{
+        MaxResults: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxResults records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeNetworkInterfacesOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeNetworkInterfaces(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.NetworkInterfaces != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.NetworkInterfaces)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var eniList []NetworkInterfaceType
+            err = json.Unmarshal(jsonData, &eniList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, eni := range eniList {
+                // Add our additional fields
+                eni = *eni.SetAccountAlias(acct.Alias)
+                eni = *eni.SetAccountId(acct.Id)
+                eni = *eni.SetRegion(region)
+                list = append(list, eni)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
@@ -0,0 +1,287 @@
+// healthcheck.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/route53"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS route53.HealthCheck type to include these additional fields
+type HealthCheckType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Status        *string   // Checkers reporting success as of the update, e.g. '15/16 ok'
+    *route53.HealthCheck
+}
+
+// Register this resource kind with the stores
+var HealthCheckKind = RegisterStoreKind("DNS health check", DNSCheckDataFile)
+
+// Health check types that have checkers reporting on their endpoint
+var endpointHealthCheckTypes = []string{"HTTP", "HTTPS", "HTTP_STR_MATCH", "HTTPS_STR_MATCH", "TCP"}
+
+// Return string representation of this type
+func (s HealthCheckType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *HealthCheckType) SetAccountAlias(v string) *HealthCheckType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *HealthCheckType) SetAccountId(v string) *HealthCheckType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Status field's value
+func (s *HealthCheckType) SetStatus(v string) *HealthCheckType {
+    s.Status = &v
+    return s
+}
+
+
+// Display all DNS health checks with applied filter. With option -dhu only display those not
+// referenced by any DNS record, or by any other health check
+func ListHealthChecks(filter string, option string) {
+    list, err := GetHealthCheckList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    var referenced []string
+    if option == "-dhu" {
+        referenced = GetReferencedHealthCheckIds(list)
+    }
+    for _, hc := range list {
+        a, b, c, d, e, f, g := GetDetailsOfHealthCheck(hc)
+        if option == "-dhu" && strInList(a, referenced) {
+            continue
+        }
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(f, filter) || strContains(g, filter) {
+            fmt.Printf("%-36s  %-16s  %-64s  %4s  %3s  %-10s  %s\n", a, b, c, d, e, f, g)
+        }
+    }
+}
+
+
+// Return DNS health check records list from the data store
+func GetHealthCheckList() (list []HealthCheckType, err error) {
+    err = DataStore.Load(HealthCheckKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object. The endpoint is shown as a URL for HTTP and
+// TCP checks, and as the checks or alarm it relies on for the other types
+func GetDetailsOfHealthCheck(hc HealthCheckType) (hcId, hcType, endpoint, interval, threshold,
+                                                  status, acctAlias string) {
+    hcId, hcType, endpoint, interval, threshold = "-", "-", "-", "-", "-"
+    status, acctAlias = "-", "-"
+
+    if hc.HealthCheck == nil {
+        return
+    }
+    if hc.Id != nil { hcId = *hc.Id }
+    if hc.Status != nil { status = *hc.Status }
+    if hc.AccountAlias != nil { acctAlias = *hc.AccountAlias }
+    cfg := hc.HealthCheckConfig
+    if cfg == nil {
+        return
+    }
+    if cfg.Type != nil { hcType = *cfg.Type }
+    if cfg.RequestInterval != nil { interval = strconv.FormatInt(*cfg.RequestInterval, 10) }
+    if cfg.FailureThreshold != nil { threshold = strconv.FormatInt(*cfg.FailureThreshold, 10) }
+    if cfg.Disabled != nil && *cfg.Disabled { status = "disabled" }
+
+    switch {
+    case strInList(hcType, endpointHealthCheckTypes):
+        host := aws.StringValue(cfg.FullyQualifiedDomainName)
+        if host == "" { host = aws.StringValue(cfg.IPAddress) }
+        scheme := strings.ToLower(strings.TrimSuffix(hcType, "_STR_MATCH"))
+        endpoint = scheme + "://" + host
+        if cfg.Port != nil { endpoint += ":" + strconv.FormatInt(*cfg.Port, 10) }
+        endpoint += aws.StringValue(cfg.ResourcePath)
+    case hcType == "CALCULATED":
+        endpoint = "checks:" + strings.Join(aws.StringValueSlice(cfg.ChildHealthChecks), ",")
+    case cfg.AlarmIdentifier != nil:
+        endpoint = "alarm:" + aws.StringValue(cfg.AlarmIdentifier.Name)
+    }
+    return
+}
+
+
+// Return health check with given ID from given list, or nil if it's not in it
+func GetHealthCheckById(hcId string, list []HealthCheckType) *HealthCheckType {
+    for i := range list {
+        if list[i].HealthCheck != nil && aws.StringValue(list[i].Id) == hcId {
+            return &list[i]
+        }
+    }
+    return nil
+}
+
+
+// Return IDs of the health checks used by any DNS record, or by any of given calculated checks
+func GetReferencedHealthCheckIds(list []HealthCheckType) (ids []string) {
+    dnsList, _ := GetDNSList()
+    for _, dnsRec := range dnsList {
+        if dnsRec.ResourceRecordSet != nil && dnsRec.HealthCheckId != nil &&
+           !strInList(*dnsRec.HealthCheckId, ids) {
+            ids = append(ids, *dnsRec.HealthCheckId)
+        }
+    }
+    for _, hc := range list {
+        if hc.HealthCheck == nil || hc.HealthCheckConfig == nil {
+            continue
+        }
+        for _, child := range aws.StringValueSlice(hc.HealthCheckConfig.ChildHealthChecks) {
+            if !strInList(child, ids) {
+                ids = append(ids, child)
+            }
+        }
+    }
+    return ids
+}
+
+
+// Update local DNS health check store from given AWS accounts
+func UpdateLocalHealthCheckStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts to update. Health checks are global, like zones
+    var updatedAccounts []*AccountType
+    for _, sweep := range GetGlobalSweepList("route53", accounts, minutesAgo, GlobalRegion) {
+        updatedAccounts = append(updatedAccounts, sweep.Account)
+    }
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local DNS health check store.\n")
+    } else {
+        if len(updatedAccounts) < 1 {
+            // Skip update if no route53 events within last minutesAgo
+            fmt.Printf("Skipping local DNS health check store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local DNS health check store (%d accounts modified within %d minutes)\n",
+            len(updatedAccounts), minutesAgo)
+    }
+
+    // Get all records for each account concurrently
+    results := make([][]HealthCheckType, len(updatedAccounts))
+    errs := make([]error, len(updatedAccounts))
+    RunParallel("route53", len(updatedAccounts), func(i int) {
+        results[i], errs[i] = GetHealthCheckListFromAWS(updatedAccounts[i])
+    })
+
+    // Keep previous records for the accounts that failed
+    var updated []*AccountType
+    for i, acct := range updatedAccounts {
+        if UpdateReport.Add(HealthCheckKind.Name, acct, "", errs[i]) {
+            updated = append(updated, acct)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts
+    var list []HealthCheckType
+    hcList, _ := GetHealthCheckList()
+    for _, hc := range hcList {
+        if GetAccountById(updated, hc.AccountId) == nil {
+            list = append(list, hc)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, HealthCheckKind)
+    return
+}
+
+
+// Return all health check objects in given AWS account, with their last status
+func GetHealthCheckListFromAWS(acct *AccountType) (list []HealthCheckType, err error) {
+    svc := route53.New(acct.Sess, AWSConfig(AWSRegion))
+
+    params := &route53.ListHealthChecksInput{
+        MaxItems: aws.String("100"),  // This is an AWS limit
+    }
+
+    // Loop requests in case there're more than MaxItems records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *route53.ListHealthChecksOutput
+        err := CallWithRetry("route53", func() (err error) {
+            resp, err = svc.ListHealthChecks(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        for _, check := range resp.HealthChecks {
+            if check == nil || check.Id == nil {
+                continue
+            }
+            hc := HealthCheckType{HealthCheck: check}
+            // Add our additional fields
+            hc = *hc.SetAccountAlias(acct.Alias)
+            hc = *hc.SetAccountId(acct.Id)
+
+            // Only endpoint checks have checkers to report their status
+            if check.HealthCheckConfig != nil &&
+               strInList(aws.StringValue(check.HealthCheckConfig.Type), endpointHealthCheckTypes) {
+                status, err := GetHealthCheckStatusFromAWS(svc, check.Id)
+                if err != nil {
+                    return nil, err
+                }
+                hc = *hc.SetStatus(status)
+            }
+            list = append(list, hc)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.IsTruncated == nil || !*resp.IsTruncated {
+            break
+        } else {
+            params.Marker = resp.NextMarker
+        }
+    }
+    return list, nil
+}
+
+
+// Return status of health check with given ID, as the number of its checkers last reporting
+// success out of all of them, e.g. '15/16 ok'
+func GetHealthCheckStatusFromAWS(svc *route53.Route53, hcId *string) (string, error) {
+    var resp *route53.GetHealthCheckStatusOutput
+    err := CallWithRetry("route53", func() (err error) {
+        resp, err = svc.GetHealthCheckStatus(&route53.GetHealthCheckStatusInput{HealthCheckId: hcId})
+        return err
+    })
+    if err != nil {
+        return "", err
+    }
+    ok := 0
+    for _, obs := range resp.HealthCheckObservations {
+        if obs != nil && obs.StatusReport != nil &&
+           strings.HasPrefix(aws.StringValue(obs.StatusReport.Status), "Success") {
+            ok++
+        }
+    }
+    return fmt.Sprintf("%d/%d ok", ok, len(resp.HealthCheckObservations)), nil
+}
@@ -7,17 +7,9 @@ import (
     "path/filepath"
     "strings"
     "strconv"
-    "time"
-    "errors"
     "encoding/json"
     "io/ioutil"
-    "net/http"
     "github.com/vaughan0/go-ini"
-    "github.com/aws/aws-sdk-go/aws"
-    "github.com/aws/aws-sdk-go/aws/session"
-    "github.com/aws/aws-sdk-go/service/iam"
-    "github.com/aws/aws-sdk-go/service/ec2"
-    "github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
 
@@ -30,18 +22,11 @@ func Die(code int, message string) {
 }
 
 
-// Write generic JSON object list to local file
-func WriteList(jsonObject interface{}, storeFile string) {
+// Write generic JSON object list to the data store, reporting any failure
+func WriteList(jsonObject interface{}, kind *StoreKind) {
     // The generic interface{} allows us to write list of any types
-    localFile := filepath.Join(progConfDir, storeFile)  // Note progConfDir is global
-    jsonData, err := json.Marshal(jsonObject)
-    if err != nil {
-        panic(err.Error())
-    }
-    err = ioutil.WriteFile(localFile, jsonData, 0600)
-    if err != nil {
-        panic(err.Error())
-    }
+    err := DataStore.Save(kind, jsonObject)
+    UpdateReport.Add(kind.Name + " store", nil, "", err)
 }
 
 
@@ -85,6 +70,29 @@ func ProcessConfigFile() {
             Die(1, "Error. r53_api_seconds_delay not defined in " + confFile)
         }
         R53APISecondsDelay, _ = strconv.Atoi(tmpR53APISecondsDelay)
+        AWSRegions, _ = cfgfile.Get("default", "regions")   // Optional
+        AWSAccounts = GetAccountsFromConfig(cfgfile)        // Optional
+        tmpMaxAttempts, _ := cfgfile.Get("default", "api_max_attempts")     // Optional
+        if tmpMaxAttempts != "" {
+            APIMaxAttempts, _ = strconv.Atoi(tmpMaxAttempts)
+        }
+        tmpMaxElapsed, _ := cfgfile.Get("default", "api_max_elapsed_seconds")  // Optional
+        if tmpMaxElapsed != "" {
+            APIMaxElapsedSeconds, _ = strconv.Atoi(tmpMaxElapsed)
+        }
+        tmpMaxWorkers, _ := cfgfile.Get("default", "max_workers")      // Optional
+        if tmpMaxWorkers != "" {
+            MaxWorkers, _ = strconv.Atoi(tmpMaxWorkers)
+        }
+        for service, value := range cfgfile.Section("workers") {     // Optional
+            ServiceWorkers[service], _ = strconv.Atoi(value)
+        }
+        for service, value := range cfgfile.Section("rates") {       // Optional
+            ServiceRates[service], _ = strconv.Atoi(value)
+        }
+        for name, value := range cfgfile.Section("columns") {     // Optional
+            ColumnPresets[name] = value
+        }
     }
 }
 
@@ -99,6 +107,27 @@ func CreateSkeltonConfigFile() {
         content += "s3_url_base = " + S3URLBase + "\n"
         content += "api_seconds_delay = " + strconv.Itoa(APISecondsDelay) + "\n"
         content += "r53_api_seconds_delay = " + strconv.Itoa(R53APISecondsDelay) + "\n"
+        content += "# Regions to update with -u, e.g., 'us-east-1,us-west-2', or 'all' for all enabled regions\n"
+        content += "# regions = all\n"
+        content += "# Accounts to update with -u, one section each, instead of just the current CLI logon\n"
+        content += "# [account production]\n"
+        content += "# profile = production\n"
+        content += "# [account staging]\n"
+        content += "# role_arn = arn:aws:iam::123456789012:role/awsinfo\n"
+        content += "# external_id = secret\n"
+        content += "# Retry budget of each throttled or failed AWS API call\n"
+        content += "# api_max_attempts = " + strconv.Itoa(APIMaxAttempts) + "\n"
+        content += "# api_max_elapsed_seconds = " + strconv.Itoa(APIMaxElapsedSeconds) + "\n"
+        content += "# Max concurrent collectors during -u, overall and per service, and max API requests\n"
+        content += "# per second per service\n"
+        content += "# max_workers = " + strconv.Itoa(MaxWorkers) + "\n"
+        content += "# [workers]\n"
+        content += "# route53 = " + strconv.Itoa(ServiceWorkers["route53"]) + "\n"
+        content += "# [rates]\n"
+        content += "# route53 = " + strconv.Itoa(ServiceRates["route53"]) + "\n"
+        content += "# Column presets for --columns, e.g., '--columns owners'\n"
+        content += "# [columns]\n"
+        content += "# owners = name,id,state,tag:Team,tag:CostCenter\n"
         err = ioutil.WriteFile(confFile, []byte(content), 0600)
         if err != nil {
             panic(err.Error())
@@ -109,80 +138,55 @@ func CreateSkeltonConfigFile() {
 }
 
 
-// Set AWS account ID and Alias, which also implicitly tests whether user is logged in or not
-func SetupAWSAccess() {
+// Set up access to the account of the current CLI logon, which also tests whether user is logged in or not
+func SetupAWSAccess() *AccountType {
     SetAWSRegion()
-
-    sess := session.Must(session.NewSession())
-
-    // Set AWS account ID globally
-    svc := ec2.New(sess, aws.NewConfig().WithRegion(AWSRegion))
-    resp, err := svc.DescribeSecurityGroups(&ec2.DescribeSecurityGroupsInput{})
-    if err == nil {
-        AWSAccountId = *resp.SecurityGroups[0].OwnerId
-        // There's no SDK call to find the AccountId, but default OwnerId of all SG is it
-    } else {
-        fmt.Println(err.Error())        
-    }
-
-    // Set AWS account alias globally
-    svc2 := iam.New(sess, aws.NewConfig().WithRegion(AWSRegion))
-    resp2, err2 := svc2.ListAccountAliases(&iam.ListAccountAliasesInput{})
-    if err2 == nil {
-        AWSAccountAlias = *resp2.AccountAliases[0]
-    } else {
-        fmt.Println(err2.Error())
-    }
-    
-    if AWSAccountId == "" || AWSAccountAlias == "" {
+    acct := &AccountType{Name: "default"}
+    if err := SetupAccountAccess(acct); err != nil {
+        fmt.Println(err.Error())
         fmt.Println("AWS login is required.")
         os.Exit(1)
     }
+    return acct
 }
 
 
 // Copy local store files to S3 bucket area defined in config file
 func CopyLocalStoresToS3Bucket(option string) {
-    SetAWSRegion()
-    sess := session.Must(session.NewSession())
-    uploader := s3manager.NewUploader(sess)
+    localStore := &LocalStore{Dir: progConfDir}
+    remoteStore := &S3Store{Bucket: S3Bucket, URLBase: S3URLBase}
 
-    fileList := []string{DNSDataFile, ZoneDataFile, ELBDatafile, InstanceDataFile, StackDataFile}
-
-    for _, file := range fileList {
-        localFileTimestamp := GetLocalFileTime(file)
-        remoteFileTimestamp := GetRemoteFileTime(file)
+    for _, kind := range StoreKinds {
+        // Stores that were never updated locally have nothing to upload, even with the Force option
+        localTime := localStore.Stat(kind)
+        if localTime.IsZero() {
+            fmt.Printf("Skipping %s. There's no local copy.\n", kind.DataFile)
+            continue
+        }
         // Update S3 copy only if local one is newer or we have the Force option
-        if localFileTimestamp.After(remoteFileTimestamp) || option == "-3f" {
-            // Open local file
-            localFile := filepath.Join(progConfDir, file)
-            f, err  := os.Open(localFile)
+        if localTime.After(remoteStore.Stat(kind)) || option == "-3f" {
+            // Copy the records verbatim, without decoding them into their type
+            var list json.RawMessage
+            err := localStore.Load(kind, &list)
             if err != nil {
                 panic(err.Error())
             }
-            // Upload to S3
-            params := &s3manager.UploadInput{
-                Bucket: aws.String(S3Bucket),   // S3Bucket is a global variable
-                Key:    aws.String(file),
-                Body:   f,
-            }
-            result, err := uploader.Upload(params)
+            err = remoteStore.Save(kind, list)
             if err != nil {
                 panic(err.Error())
             }
-            fmt.Printf("Remote upload to %s\n", aws.StringValue(&result.Location))
+            fmt.Printf("Remote upload to %s/%s\n", S3URLBase, kind.DataFile)
         } else {
-            fmt.Printf("Skipping %s. The S3 copy is newer than local one.\n", file)
-        }     
+            fmt.Printf("Skipping %s. The S3 copy is newer than local one.\n", kind.DataFile)
+        }
     }
 }
 
 
 // Delete, clean up the local store files
 func DeleteLocalStoresFiles(option string) {
-    fileList := []string{DNSDataFile, ZoneDataFile, ELBDatafile,InstanceDataFile,StackDataFile}
-    for _, file := range fileList {
-        localFile := filepath.Join(progConfDir, file)
+    for _, kind := range StoreKinds {
+        localFile := filepath.Join(progConfDir, kind.DataFile)
         os.Remove(localFile)
     }
 }
@@ -230,15 +234,6 @@ func strContains(str string, arg string) bool {
 }
 
 
-// Check for AWS throttling
-func BeingThrottled(err error) bool {
-    if strContains(err.Error(), "Throttl") || strContains(err.Error(), "exceed") {
-        return true
-    }
-    return false
-}
-
-
 // Check if string element is in string list 
 func strInList(element string, list []string) bool {
     for _, str := range list {
@@ -248,115 +243,3 @@ func strInList(element string, list []string) bool {
     }
     return false
 }
-
-
-// Return local file time in UTC
-func GetLocalFileTime(dataFile string) (t time.Time) {
-    localFile := filepath.Join(progConfDir, dataFile)
-    fileinfo, err := os.Stat(localFile)
-    if err != nil {
-        return t  // Return zero time
-    }
-    return fileinfo.ModTime().UTC()
-}
-
-
-// Return remote file time in UTC
-func GetRemoteFileTime(dataFile string) (t time.Time) {
-    S3FileUrl := S3URLBase + "/" + dataFile
-    resp, err := http.Head(S3FileUrl)
-    if err == nil && resp.StatusCode == 200 {
-        lastModifiedDate := resp.Header.Get("Last-Modified")
-        lmt, err := time.Parse(time.RFC1123, lastModifiedDate)
-        if err == nil {
-            return lmt.UTC()
-        }
-    }
-    // If anything, just return zero time
-    return t
-}
-
-
-// Return list in local store
-func GetListFromLocal(dataFile string) (list interface{}, err error) {
-    localFile := filepath.Join(progConfDir, dataFile)  // progConfDir is global
-    jsonData, err := ioutil.ReadFile(localFile)
-    if err != nil {
-        // Return empty list of respective type
-        list, _ := GetListFromJSONData(dataFile, &jsonData)
-        return list, errors.New(fmt.Sprintf("Can't read file %s", localFile))
-    }
-    // Return specific type list with error
-    return GetListFromJSONData(dataFile, &jsonData)
-}
-
-
-// Return list in remote store
-func GetListFromRemote(dataFile string) (list interface{}, err error) {
-    S3FileUrl := S3URLBase + "/" + dataFile
-    resp, err := http.Get(S3FileUrl)
-    if err != nil {
-        // Return empty interface list with error
-        return list, errors.New(fmt.Sprintf("Can't http.Get %s", S3FileUrl))
-    } else {
-        if resp.StatusCode == 200 {
-            jsonData, err := ioutil.ReadAll(resp.Body)
-            if err != nil {
-                return list, errors.New(fmt.Sprintf("Can't read body of %s", S3FileUrl))
-            }
-            // Return specific type list with error
-            return GetListFromJSONData(dataFile, &jsonData)
-        }
-        // Return empty list with error
-        return list, errors.New(fmt.Sprintf("URL %s returns a non-200 error", S3FileUrl))
-    }
-}
-
-
-// Return list in json data
-func GetListFromJSONData(dataFile string, jsonData *[]byte) (list interface{}, err error) {
-    switch dataFile {
-    case InstanceDataFile: // Return list of instance records
-        var list []InstanceType
-        err := json.Unmarshal(*jsonData, &list)
-        if err != nil {
-            return list, errors.New(fmt.Sprintf("Can't unmarshal %s", dataFile))
-        }
-        return list, err
-    case DNSDataFile:      // Return list of DNS records
-        var list []ResourceRecordSetType
-        err := json.Unmarshal(*jsonData, &list)
-        if err != nil {
-            return list, errors.New(fmt.Sprintf("Can't unmarshal %s", dataFile))
-        }
-        return list, err
-    case ELBDatafile:      // Return list of ELB records
-        var list []LoadBalancerDescriptionType
-        err := json.Unmarshal(*jsonData, &list)
-        if err != nil {
-            return list, errors.New(fmt.Sprintf("Can't unmarshal %s", dataFile))
-        }
-        return list, err
-    case ZoneDataFile:     // Return list of zone records
-        var list []HostedZoneType
-        err := json.Unmarshal(*jsonData, &list)
-        if err != nil {
-            return list, errors.New(fmt.Sprintf("Can't unmarshal %s", dataFile))
-        }
-        return list, err
-    case StackDataFile:    // Return list of stack records
-        var list []StackType
-        err := json.Unmarshal(*jsonData, &list)
-        if err != nil {
-            return list, errors.New(fmt.Sprintf("Can't unmarshal %s", dataFile))
-        }
-        return list, err
-    default:               // Return list of generic JSON records
-        var list interface{}
-        err := json.Unmarshal(*jsonData, &list)
-        if err != nil {
-            return list, errors.New(fmt.Sprintf("Can't unmarshal %s", dataFile))
-        }
-        return list, err
-    }
-}
@@ -3,11 +3,9 @@ package main
 
 import (
     "fmt"
-    "time"
     "strings"
     "encoding/json"
     "github.com/aws/aws-sdk-go/aws"
-    "github.com/aws/aws-sdk-go/aws/session"
     "github.com/aws/aws-sdk-go/service/ec2"
     "github.com/aws/aws-sdk-go/aws/awsutil"
 )
@@ -17,9 +15,13 @@ import (
 type InstanceType struct {
     AccountAlias  *string
     AccountId     *string
+    Region        *string
     *ec2.Instance
 }
 
+// Register this resource kind with the stores
+var InstanceKind = RegisterStoreKind("EC2 instance", InstanceDataFile)
+
 // Return string representation of this type
 func (s InstanceType) String() string {
     return awsutil.Prettify(s)
@@ -37,58 +39,75 @@ func (s *InstanceType) SetAccountId(v string) *InstanceType {
     return s
 }
 
+// Set Region field's value
+func (s *InstanceType) SetRegion(v string) *InstanceType {
+    s.Region = &v
+    return s
+}
+
+// Query fields of EC2 instances, e.g. 'state=running type~^m5'
+var InstanceFields = []string{"name", "id", "type", "state", "ip", "account", "launched", "env",
+    "billing", "az", "subnet", "vpc", "image", "key", "profile", "role", "asg", "region",
+    "subnetid", "vpcid"}
+
 // Display all EC2 instances with applied filter
 func ListInstances(filter string, option string) {
+    query := ParseQueryOrDie(filter, InstanceFields)
     instList, err := GetInstanceList()
     if err != nil {
         Die(1, err.Error())
     }
+    // Only needed for verbose listing, and fine if there're no stores for them yet
+    asgList, _ := GetASGList()
+    subnetNames, vpcNames := GetSubnetNameMap(), GetVpcNameMap()
+    volList, _ := GetVolumeList()
+    volumes := GetVolumesByInstance(volList)
+    roleList, _ := GetRoleList()
+    profileList, _ := GetInstanceProfileList()
+    listing := NewListing(InstanceFields)
     for _, inst := range instList {
         // Using single letters for better readability
         a, b, c, d, e, f, g, h, k, l, m, n, o, p := GetInstanceDetails(&inst)
-        // Apply filter string on all attributes
-        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
-           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
-           strContains(f, filter) || strContains(g, filter) || strContains(h, filter) ||
-           strContains(k, filter) || strContains(l, filter) || strContains(m, filter) ||
-           strContains(n, filter) || strContains(o, filter) || strContains(p, filter) {
-
+        // Show subnet and VPC by name where we know it
+        vpc := "-"
+        if inst.VpcId != nil { vpc = *inst.VpcId }
+        if name, ok := subnetNames[m]; ok { m = name }
+        if name, ok := vpcNames[vpc]; ok { vpc = name }
+        // Show the role of the instance profile where we know it
+        role := p
+        if r := GetRoleOfInstance(&inst, profileList, roleList); r != nil {
+            role = aws.StringValue(r.RoleName)
+        }
+        asg := GetASGNameOfInstance(&inst, asgList)
+        // Apply query on all attributes
+        fields := NewFieldList(InstanceFields, a, b, c, d, e, f, g, h, k, l, m, vpc, n, o, p, role,
+            asg, aws.StringValue(inst.Region), aws.StringValue(inst.SubnetId),
+            aws.StringValue(inst.VpcId))
+        if !query.Match(fields) {
+            continue
+        }
+        listing.Add(fields, inst, func() {
             //  Replace spaces with period and shorten names
             if len(a) > 38 { a = a[:38] }
             a = strings.Replace(a, " ", ".", -1)
 
             if option == "-iv" {
                 fmt.Printf("%-38s  %-20s  %-12s  %-10s  %-16s  %-18s  " + 
-                           "%-18s  %-12s  %-6s  %-12s  %-16s  %-14s  %-14s  %-70s\n",
-                           a, b, c, d, e, f, g, h, k, l, m, n, o, p)
+                           "%-18s  %-12s  %-6s  %-12s  %-24s  %-24s  %-14s  %-14s  %-70s  %s\n",
+                           a, b, c, d, e, f, g, h, k, l, m, vpc, n, o, role, asg)
+                PrintInstanceVolumes(b, volumes[b], "    ")
             } else {
                 fmt.Printf("%-38s  %-20s  %-12s  %-10s  %-16s  %-18s  %-18s\n", a, b, c, d, e, f, g)
             }
-        }
+        })
     }
+    listing.Flush()
 }
 
 
-// Return newest instance list between local and remote store
+// Return instance records list from the data store
 func GetInstanceList() (list []InstanceType, err error) {
-    localFileTimestamp := GetLocalFileTime(InstanceDataFile)
-    remoteFileTimestamp := GetRemoteFileTime(InstanceDataFile)
-
-    // Use remote S3 file if it's newer
-    if remoteFileTimestamp.After(localFileTimestamp) {
-        tmplist, err := GetListFromRemote(InstanceDataFile)
-        list = tmplist.([]InstanceType)    // Assert our Instance type
-        if err == nil {
-            WriteList(list, InstanceDataFile)   // Update local with this newer set
-            return list, nil
-        }
-        // Return what must be an empty list with the error code
-        return list, err
-    }
-
-    // Else, just return local file content with error code
-    tmplist, err := GetListFromLocal(InstanceDataFile)
-    list = tmplist.([]InstanceType)
+    err = DataStore.Load(InstanceKind, &list)
     return list, err
 }
 
@@ -149,71 +168,81 @@ func GetInstanceDetails(inst *InstanceType) (Name string,             // a
 }
 
 
-// Update local instance store from current AWS account
-func UpdateLocalInstanceStoreFromAWS(minutesAgo int) {
+// Update local instance store from given AWS accounts
+func UpdateLocalInstanceStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("ec2", accounts, minutesAgo)
+
     // Do full update if minutesAgo is zero (meaning it wasn't specified)
     if minutesAgo == 0 {
         fmt.Printf("Updating local EC2 instance store.\n")
     } else {
-        updatedInstCount := len(GetCloudTrailEvents("ec2", minutesAgo))
-        if updatedInstCount < 1 {
-            // Skip update if no EC2 events within ast minutesAgo
+        if len(sweeps) < 1 {
+            // Skip update if no EC2 events within last minutesAgo
             fmt.Printf("Skipping local EC2 instance store update (no mods within %d minutes)\n",
                 minutesAgo)
             return
         }
-        fmt.Printf("Updating local EC2 instance store (%d modified within %d minutes)\n",
-            updatedInstCount, minutesAgo)
+        fmt.Printf("Updating local EC2 instance store (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    results := make([][]InstanceType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("ec2", len(sweeps), func(i int) {
+        results[i], errs[i] = GetInstanceListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(InstanceKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
     }
 
-    // Create a new list from existing store, without the ones for the current AWS account
+    // Create a new list from existing store, without the ones for the updated accounts and regions
     var list []InstanceType
     instList, _ := GetInstanceList()
     for _, inst := range instList {
-        if *inst.AccountId != AWSAccountId {
+        if !RecordInSweep(inst.AccountId, inst.Region, updated) {
             list = append(list, inst)
         }
     }
 
-    // Now get all records for this account, and add them to this new list
-    for _, inst := range GetInstanceListFromAWS() {
-        list = append(list, inst)
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
     }
 
     // Make this the new local list
-    WriteList(list, InstanceDataFile)
+    WriteList(list, InstanceKind)
     return
 }
 
 
-// Return all instance objects in current AWS account
-func GetInstanceListFromAWS() (list []InstanceType) {
-    SetAWSRegion()
-    sess := session.Must(session.NewSession())
-    svc := ec2.New(sess, aws.NewConfig().WithRegion(AWSRegion))
+// Return all instance objects in given account and region
+func GetInstanceListFromAWS(acct *AccountType, region string) (list []InstanceType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
 
     params := &ec2.DescribeInstancesInput{
         MaxResults: aws.Int64(500),   // Max is 1000, but we'll get in 500 size sets
     }
 
-    // Loop requests in case there're more than PageSize records or we're being throttled
-    errcount := 0
+    // Loop requests in case there're more than PageSize records
     for {
-        // Get batch of records
-        resp, err := svc.DescribeInstances(params)
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeInstancesOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeInstances(params)
+            return err
+        })
         if err != nil {
-            // Sleep for a moment if AWS is throttling us
-            if BeingThrottled(err) {
-                fmt.Printf("  AWS throttling. Sleeping %d seconds...\n", APISecondsDelay)
-                time.Sleep(time.Duration(APISecondsDelay) * time.Second)
-                continue
-            }
-            // Allow for 3 other unknown API call errors before panicking
-            if errcount < 3 {
-                errcount++
-                continue
-            }
-            panic(err.Error())   // Abort on any other error
+            return nil, err
         }
 
         // Ensure valid data came back
@@ -225,19 +254,20 @@ func GetInstanceListFromAWS() (list []InstanceType) {
                     // First convert it to raw []byte
                     jsonData, err := json.Marshal(resp.Reservations[i].Instances)
                     if err != nil {
-                        panic(err.Error())
+                        return nil, err
                     }
                     // Now read it into extended type list
                     var instList []InstanceType
                     err = json.Unmarshal(jsonData, &instList)
                     if err != nil {
-                        panic(err.Error())
+                        return nil, err
                     }
                     // Add this batch to our list
                     for _, inst := range instList {
                         // Add our additional fields
-                        inst = *inst.SetAccountAlias(AWSAccountAlias)
-                        inst = *inst.SetAccountId(AWSAccountId)
+                        inst = *inst.SetAccountAlias(acct.Alias)
+                        inst = *inst.SetAccountId(acct.Id)
+                        inst = *inst.SetRegion(region)
                         list = append(list, inst)
                     }
                 }
@@ -251,5 +281,5 @@ func GetInstanceListFromAWS() (list []InstanceType) {
             params.NextToken = resp.NextToken
         }
     }
-    return list
+    return list, nil
 }
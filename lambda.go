@@ -0,0 +1,246 @@
+// lambda.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "strconv"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/lambda"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS lambda.FunctionConfiguration type to include these additional fields
+type FunctionType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    Tags          map[string]*string
+    *lambda.FunctionConfiguration
+}
+
+// Register this resource kind with the stores
+var LambdaKind = RegisterStoreKind("Lambda function", LambdaDataFile)
+
+// Return string representation of this type
+func (s FunctionType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *FunctionType) SetAccountAlias(v string) *FunctionType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *FunctionType) SetAccountId(v string) *FunctionType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *FunctionType) SetRegion(v string) *FunctionType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all Lambda functions with applied filter
+func ListLambdas(filter string) {
+    list, err := GetLambdaList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    for _, fn := range list {
+        a, b, c, d, e, f, g, h, k := GetDetailsOfLambda(fn)
+        tags := GetLambdaTagString(fn)
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(f, filter) || strContains(g, filter) || strContains(h, filter) ||
+           strContains(k, filter) || strContains(tags, filter) {
+            fmt.Printf("%-48s  %-14s  %5s  %4s  %-16s  %-18s  %-14s  %-22s  %s\n",
+                a, b, c, d, e, f, g, h, k)
+        }
+    }
+}
+
+
+// Return Lambda function records list from the data store
+func GetLambdaList() (list []FunctionType, err error) {
+    err = DataStore.Load(LambdaKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfLambda(fn FunctionType) (Name string,            // a
+                                          Runtime string,         // b
+                                          Memory string,          // c
+                                          Timeout string,         // d
+                                          LastModified string,    // e
+                                          AccountAlias string,    // f
+                                          Region string,          // g
+                                          VpcId string,           // h
+                                          Role string) {          // k
+    Name, Runtime, Memory, Timeout, LastModified = "-", "-", "-", "-", "-"
+    AccountAlias, Region, VpcId, Role = "-", "-", "-", "-"
+
+    if fn.FunctionName != nil { Name = *fn.FunctionName }
+    if fn.Runtime != nil {
+        Runtime = *fn.Runtime
+    } else if fn.PackageType != nil {
+        Runtime = *fn.PackageType   // Container image functions have no runtime
+    }
+    if fn.MemorySize != nil { Memory = strconv.FormatInt(*fn.MemorySize, 10) }
+    if fn.Timeout != nil { Timeout = strconv.FormatInt(*fn.Timeout, 10) }
+    if fn.LastModified != nil {
+        // Shorten e.g. '2019-05-01T12:34:56.789+0000' to the same format as instance launch times
+        LastModified = strings.Replace(*fn.LastModified, "T", " ", 1)
+        if len(LastModified) > 16 { LastModified = LastModified[:16] }
+    }
+    if fn.AccountAlias != nil { AccountAlias = *fn.AccountAlias }
+    if fn.Region != nil { Region = *fn.Region }
+    if fn.VpcConfig != nil && fn.VpcConfig.VpcId != nil && *fn.VpcConfig.VpcId != "" {
+        VpcId = *fn.VpcConfig.VpcId
+    }
+    if fn.Role != nil {
+        // Show just the role name, which is the last part of its ARN
+        parts := strings.Split(*fn.Role, "/")
+        Role = parts[len(parts) - 1]
+    }
+    return
+}
+
+
+// Return given function's tags as a sorted, space-separated string of KEY=VALUE pairs
+func GetLambdaTagString(fn FunctionType) string {
+    var tags []string
+    for k, v := range fn.Tags {
+        tags = append(tags, k + "=" + aws.StringValue(v))
+    }
+    sort.Strings(tags)
+    return strings.Join(tags, " ")
+}
+
+
+// Return function with given ARN from given list, or nil if it's not in it. Qualified ARNs,
+// i.e., with a version or alias at the end, match their function too
+func GetLambdaByArn(arn string, list []FunctionType) *FunctionType {
+    for i := range list {
+        fnArn := aws.StringValue(list[i].FunctionArn)
+        if fnArn != "" && (arn == fnArn || strings.HasPrefix(arn, fnArn + ":")) {
+            return &list[i]
+        }
+    }
+    return nil
+}
+
+
+// Update local Lambda function store from given AWS accounts
+func UpdateLocalLambdaStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("lambda", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local Lambda store.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no Lambda events within last minutesAgo
+            fmt.Printf("Skipping local Lambda store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local Lambda store (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    results := make([][]FunctionType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("lambda", len(sweeps), func(i int) {
+        results[i], errs[i] = GetLambdaListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(LambdaKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts and regions
+    var list []FunctionType
+    fnList, _ := GetLambdaList()
+    for _, fn := range fnList {
+        if !RecordInSweep(fn.AccountId, fn.Region, updated) {
+            list = append(list, fn)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, LambdaKind)
+    return
+}
+
+
+// Return all Lambda function objects in given account and region, with their tags
+func GetLambdaListFromAWS(acct *AccountType, region string) (list []FunctionType, err error) {
+    svc := lambda.New(acct.Sess, AWSConfig(region))
+
+    params := &lambda.ListFunctionsInput{
+        MaxItems: aws.Int64(50),  // 50 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxItems records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *lambda.ListFunctionsOutput
+        err := CallWithRetry("lambda", func() (err error) {
+            resp, err = svc.ListFunctions(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        for _, config := range resp.Functions {
+            if config == nil || config.FunctionArn == nil {
+                continue
+            }
+            // Tags don't come with the list, so get them for each function
+            var resp2 *lambda.ListTagsOutput
+            err := CallWithRetry("lambda", func() (err error) {
+                resp2, err = svc.ListTags(&lambda.ListTagsInput{Resource: config.FunctionArn})
+                return err
+            })
+            if err != nil {
+                return nil, err
+            }
+            fn := FunctionType{Tags: resp2.Tags, FunctionConfiguration: config}
+            // Add our additional fields
+            fn = *fn.SetAccountAlias(acct.Alias)
+            fn = *fn.SetAccountId(acct.Id)
+            fn = *fn.SetRegion(region)
+            list = append(list, fn)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextMarker == nil {
+            break
+        } else {
+            params.Marker = resp.NextMarker
+        }
+    }
+    return list, nil
+}
@@ -10,44 +10,84 @@ import (
 
 // Global constants
 const (
-    ProgName         = "awsinfo"
-    ProgVer          = "2.0.11"
-    DNSDataFile      = "dns.json"
-    ZoneDataFile     = "zone.json"
-    ELBDatafile      = "elb.json"
-    InstanceDataFile = "inst.json"
-    StackDataFile    = "stack.json"
+    ProgName           = "awsinfo"
+    ProgVer            = "2.0.11"
+    DNSDataFile        = "dns.json"
+    ZoneDataFile       = "zone.json"
+    ELBDatafile        = "elb.json"
+    ELBV2DataFile      = "elbv2.json"
+    InstanceDataFile   = "inst.json"
+    StackDataFile      = "stack.json"
+    ASGDataFile        = "asg.json"
+    DBInstanceDataFile = "rdsinst.json"
+    DBClusterDataFile  = "rdscluster.json"
+    SecGroupDataFile   = "secgroup.json"
+    VpcDataFile        = "vpc.json"
+    SubnetDataFile     = "subnet.json"
+    RouteTableDataFile = "routetable.json"
+    CertDataFile       = "cert.json"
+    LambdaDataFile     = "lambda.json"
+    ECSClusterDataFile = "ecscluster.json"
+    ECSServiceDataFile = "ecsservice.json"
+    ECSTaskDataFile    = "ecstask.json"
+    CloudFrontDataFile = "cloudfront.json"
+    BucketDataFile     = "bucket.json"
+    VolumeDataFile     = "volume.json"
+    SnapshotDataFile   = "snapshot.json"
+    AddressDataFile    = "eip.json"
+    ENIDataFile        = "eni.json"
+    DNSCheckDataFile   = "healthcheck.json"
+    RoleDataFile       = "role.json"
+    ProfileDataFile    = "instprofile.json"
 )
 
 // Global variables
 var (
     progConfDir     = ""    // This gets set to $HOME/.${ProgName} in ProcessConfigFile()
     AWSRegion       = ""
-    AWSAccountId    = ""
-    AWSAccountAlias = ""
     // Below hard-coded values can be overriden via $HOME/.awi/config
     S3Bucket           = "awsinfo"
     S3URLBase          = "https://s3.amazonaws.com/awsinfo"
     APISecondsDelay    = 1
     R53APISecondsDelay = 180
+    AWSRegions         = ""   // Regions to sweep with -u, empty meaning just AWSRegion
+    AWSAccounts        []*AccountType   // Accounts to sweep with -u, empty meaning just the CLI logon
 )
 
+// Options whose listings can be output in other formats with -o, or with other columns
+var outputOptions = []string{"-d", "-dv", "-e", "-eh", "-es", "-i", "-iv", "-s", "-sv", "-z"}
+
 
 func main() {
     ProcessConfigFile()
+    SetupDataStore()
+    SetupRetryPolicies()
 
-    // Allow only 1 or 2 arguments; an option with an optional filter
-    argCount := len(os.Args[1:])
+    // Allow an option with an optional filter. Any further arguments are taken as more filter
+    // terms, so queries like 'state=running env=prod' needn't be quoted
+    args := ProcessGlobalOptions(os.Args[1:])
+    argCount := len(args)
     option, filter := "", ""
     if argCount == 1 {
-        option = os.Args[1]
-    } else if argCount == 2 {
-        option = os.Args[1]
-        // From hereon all filtering comparisons are done in lowercase
-        filter = strings.ToLower(os.Args[2])
+        option = args[0]
+    } else if argCount >= 2 {
+        option = args[0]
+        // Filtering comparisons are all case-insensitive
+        filter = strings.Join(args[1:], " ")
     } else {
         PrintUsage(option)
     }
+    if (OutputFormat != "" || OutputColumns != nil || OutputTemplate != nil || OutputSort != nil ||
+        OutputGroups != nil || OutputCount) && !strInList(option, outputOptions) {
+        Die(1, "Error. Output formats, columns, templates, sorting and counts are only supported by " +
+            "options " + strings.Join(outputOptions, " "))
+    }
+    if OutputTemplate != nil && (OutputFormat != "" || OutputColumns != nil) {
+        Die(1, "Error. --template can't be combined with an output format or columns")
+    }
+    if OutputCount && (OutputTemplate != nil || OutputColumns != nil) {
+        Die(1, "Error. --count can't be combined with columns or a template")
+    }
 
     // Process given option with optional filter
     if option == "-u" {
@@ -66,13 +106,17 @@ func main() {
                 targetZones = strings.Split(filter, ",")
             }
         }
-        // Setup AWS access and update all stores
-        SetupAWSAccess()
-        UpdateLocalInstanceStoreFromAWS(minutesAgo)
-        UpdateLocalZoneStoreFromAWS(minutesAgo)
-        UpdateLocalELBStoreFromAWS(minutesAgo)
-        UpdateLocalStackStoreFromAWS(minutesAgo)
-        UpdateLocalDNSStoreFromAWS(targetZones, minutesAgo)
+        // Setup AWS access to each account, and update all stores in one pass
+        allAccounts := GetAccountList()
+        accounts := SetupAccounts(allAccounts)
+        if len(accounts) == 0 {
+            Die(ExitTotalFailure, "AWS login is required.")
+        }
+        for _, acct := range accounts {
+            fmt.Printf("Account %s (%s), regions: %s\n", acct.Alias, acct.Id, strings.Join(acct.Regions, ", "))
+        }
+        UpdateLocalStoresFromAWS(accounts, targetZones, minutesAgo)
+        Die(PrintUpdateReport(allAccounts), "")
     } else if option == "-3" || option == "-3f" {
         SetupAWSAccess()
         CopyLocalStoresToS3Bucket(option)
@@ -84,16 +128,60 @@ func main() {
         ListZones(filter)
     } else if option == "-d" || option == "-dv"  {
         ListDNS(filter, option)
+    } else if option == "-dh" || option == "-dhu" {
+        ListHealthChecks(filter, option)
+    } else if option == "-a" {
+        ListASGs(filter)
+    } else if option == "-b" {
+        ListBuckets(filter)
+    } else if option == "-c" || option == "-cv" {
+        ListECS(filter, option)
     } else if option == "-e" {
         ListELBRecords(filter)
     } else if option == "-es" {
         ListELBCerts(filter)
+    } else if option == "-ex" {
+        if filter == "" {
+            PrintUsage("-h")   // DAYS is required
+        }
+        ListExpiringCerts(filter)
     } else if option == "-eh" {
         ListELBHealthChecks(filter)    
+    } else if option == "-f" || option == "-fv" {
+        ListDistributions(filter, option)
+    } else if option == "-g" || option == "-gv" {
+        ListSecGroups(filter, option)
+    } else if option == "-gx" {
+        if filter == "" {
+            PrintUsage("-h")   // PORT is required
+        }
+        ListExposedResources(filter)
     } else if option == "-i" || option == "-iv" {
         ListInstances(filter, option)
+    } else if option == "-ir" {
+        ListRolesAndInstances(filter)
+    } else if option == "-l" {
+        ListLambdas(filter)
+    } else if option == "-n" || option == "-nv" {
+        ListNetworks(filter, option)
+    } else if option == "-q" {
+        if filter == "" {
+            PrintUsage("-h")   // STRING is required
+        }
+        SearchStores(filter)
+    } else if option == "-r" {
+        ListRDS(filter)
     } else if option == "-s" || option == "-sv" {
         ListStacks(filter, option)
+    } else if option == "-v" || option == "-vu" {
+        ListVolumes(filter, option)
+    } else if option == "-vs" {
+        ListSnapshots(filter)
+    } else if option == "-w" {
+        if filter == "" {
+            PrintUsage("-h")   // IP is required
+        }
+        ListIPOwners(filter)
     } else if filter != "" || option == "-h" {
         PrintUsage(option)
     } else {
@@ -103,27 +191,113 @@ func main() {
 }
 
 
+// Process global options anywhere in given arguments, and return the remaining ones
+func ProcessGlobalOptions(args []string) (remaining []string) {
+    for i := 0 ; i < len(args) ; i++ {
+        arg := args[i]
+        switch {
+        case arg == "-o" || arg == "--output":
+            if i + 1 >= len(args) {
+                Die(1, "Error. " + arg + " requires a FORMAT: " + strings.Join(OutputFormats, ", "))
+            }
+            i++
+            SetOutputFormat(args[i])
+        case strings.HasPrefix(arg, "--output="):
+            SetOutputFormat(strings.TrimPrefix(arg, "--output="))
+        case arg == "--columns" || arg == "--template" || arg == "--sort" || arg == "--group":
+            if i + 1 >= len(args) {
+                Die(1, "Error. " + arg + " requires a value")
+            }
+            i++
+            SetGlobalOption(arg, args[i])
+        case strings.HasPrefix(arg, "--") && strings.Contains(arg, "="):
+            parts := strings.SplitN(arg, "=", 2)
+            if !strInList(parts[0], []string{"--columns", "--template", "--sort", "--group"}) {
+                remaining = append(remaining, arg)
+                break
+            }
+            SetGlobalOption(parts[0], parts[1])
+        case arg == "--count":
+            OutputCount = true
+        default:
+            remaining = append(remaining, arg)
+        }
+    }
+    return remaining
+}
+
+
+// Set global option of given name to given value
+func SetGlobalOption(name, value string) {
+    switch name {
+    case "--columns":
+        SetOutputColumns(value)
+    case "--template":
+        SetOutputTemplate(value)
+    case "--sort":
+        SetOutputSort(value)
+    case "--group":
+        SetOutputGroups(value)
+    }
+}
+
+
 func PrintUsage(option string) {
     fmt.Printf("AWS CLI Information Utility %s\n", ProgVer)
-    fmt.Printf("%s DNSRECORD        Print IPs/ELB/instances breakdown for given DNSRECORD\n", ProgName)
-    fmt.Printf("        -e  [STRING]     List ELBs, filter with optional STRING\n")
-    fmt.Printf("        -d  [STRING]     List DNS records, filter with optional STRING\n")
-    fmt.Printf("        -i  [STRING]     List EC2 instances, filter with optional STRING\n")
-    fmt.Printf("        -s  [STRING]     List CloudFormation stacks, filter with optional STRING\n")
-    fmt.Printf("        -z  [STRING]     List DNS zones, filter with optional STRING\n")
+    fmt.Printf("%s DNSRECORD        Print DNS/CloudFront/ELB/RDS/S3/instances breakdown for given DNSRECORD\n", ProgName)
+    fmt.Printf("        -a  [STRING]     List Auto Scaling groups, filter with optional STRING\n")
+    fmt.Printf("        -b  [STRING]     List S3 buckets, filter with optional STRING\n")
+    fmt.Printf("        -c  [STRING]     List ECS clusters and services, filter with optional STRING\n")
+    fmt.Printf("        -e  [QUERY]      List ELBs, ALBs and NLBs, filter with optional QUERY\n")
+    fmt.Printf("        -d  [QUERY]      List DNS records, filter with optional QUERY\n")
+    fmt.Printf("        -f  [STRING]     List CloudFront distributions, filter with optional STRING\n")
+    fmt.Printf("        -g  [STRING]     List security groups, filter with optional STRING\n")
+    fmt.Printf("        -i  [QUERY]      List EC2 instances, filter with optional QUERY\n")
+    fmt.Printf("        -l  [STRING]     List Lambda functions, filter with optional STRING\n")
+    fmt.Printf("        -n  [STRING]     List VPCs and subnets, filter with optional STRING\n")
+    fmt.Printf("        -q  STRING       Search all stores for STRING, exact ID and name matches first\n")
+    fmt.Printf("        -r  [STRING]     List RDS clusters and instances, filter with optional STRING\n")
+    fmt.Printf("        -s  [QUERY]      List CloudFormation stacks, filter with optional QUERY\n")
+    fmt.Printf("        -v  [STRING]     List EBS volumes, filter with optional STRING\n")
+    fmt.Printf("        -z  [QUERY]      List DNS zones, filter with optional QUERY\n")
     fmt.Printf("        -h               Show extended options\n")
     if option == "-h" {
         fmt.Printf("        -3               Copy local stores to S3 bucket defined in ~/.%s/config\n", ProgName)
         fmt.Printf("        -3f              Ignore file time stamps and force above copying\n")
-        fmt.Printf("        -eh [STRING]     List ELB health-checks, filter with optional STRING\n")
-        fmt.Printf("        -es [STRING]     List ELB SSL certs, filter with optional STRING\n")
-        fmt.Printf("        -dv [STRING]     List DNS records, more verbosely\n")
-        fmt.Printf("        -iv [STRING]     List EC2 instances, more verbosely\n")
-        fmt.Printf("        -sv [STRING]     List CloudFormation stacks, more verbosely\n")
+        fmt.Printf("        -cv [STRING]     List ECS clusters and services, with their running tasks\n")
+        fmt.Printf("        -eh [STRING]     List ELB and target group health-checks, filter with optional STRING\n")
+        fmt.Printf("        -es [STRING]     List ELB, ALB and NLB SSL certs and their expiry, filter with optional STRING\n")
+        fmt.Printf("        -ex DAYS         List certs expiring within DAYS, and the ELB listeners using them\n")
+        fmt.Printf("        -dv [QUERY]      List DNS records, more verbosely, with their health checks\n")
+        fmt.Printf("        -dh [STRING]     List DNS health checks, filter with optional STRING\n")
+        fmt.Printf("        -dhu [STRING]    List DNS health checks not used by any DNS record\n")
+        fmt.Printf("        -fv [STRING]     List CloudFront distributions, with their origins and cache behaviors\n")
+        fmt.Printf("        -gv [STRING]     List security group rules, one per line\n")
+        fmt.Printf("        -gx PORT         List instances and ELBs with PORT open to 0.0.0.0/0 or ::/0\n")
+        fmt.Printf("        -iv [QUERY]      List EC2 instances, more verbosely, with their EBS volumes\n")
+        fmt.Printf("        -ir [ROLE]       List IAM roles, with the instances running under them\n")
+        fmt.Printf("        -nv [STRING]     List VPCs and subnets, with routes and instances\n")
+        fmt.Printf("        -o  FORMAT       Output -d, -e, -eh, -es, -i, -s and -z listings as json, ndjson, csv, tsv\n")
+        fmt.Printf("                         or yaml, with all their fields. Also --output FORMAT\n")
+        fmt.Printf("        --columns COLS   Show only COLS of above listings, e.g. 'name,id,tag:Team,Placement.Tenancy',\n")
+        fmt.Printf("                         or the COLS of a preset in the [columns] section of ~/.%s/config\n", ProgName)
+        fmt.Printf("        --template TMPL  Print each row of above listings with Go text/template TMPL, e.g.\n")
+        fmt.Printf("                         '{{.id}} {{tag .Record \"Team\"}} {{field .Record \"Placement.Tenancy\"}}'\n")
+        fmt.Printf("        --sort KEYS      Sort above listings by columns in KEYS, e.g. 'account,-launched', '-' for descending\n")
+        fmt.Printf("        --group KEYS     Group above listings by columns in KEYS, e.g. 'account,type', with subtotals\n")
+        fmt.Printf("        --count          Print only the count of above listings, or of each of their groups\n")
+        fmt.Printf("        -sv [QUERY]      List CloudFormation stacks, more verbosely\n")
         fmt.Printf("        -u  [MIN|ZONES]  Update local stores, and only DNS records changed in last MIN minutes,\n")
         fmt.Printf("                         or from zones in ZONES string, e.g., 'mysite.com,a.mydns.com,site.io'\n")
+        fmt.Printf("        -vs [STRING]     List EBS snapshots, filter with optional STRING\n")
+        fmt.Printf("        -vu [STRING]     List unattached EBS volumes, filter with optional STRING\n")
+        fmt.Printf("        -w  IP           List instances, network interfaces, Elastic IPs and ELBs owning IP\n")
         fmt.Printf("        -x               Delete local store, to start afresh\n")
         fmt.Printf("        -y               Create skeleton ~/.%s/config file\n", ProgName)
+        fmt.Printf("QUERY is STRING, or terms like 'state=running env=prod type~^m5 !name:bastion', where\n")
+        fmt.Printf("FIELD=VALUE is equal, FIELD:VALUE contains and FIELD~REGEX matches, ! negates, and terms\n")
+        fmt.Printf("are AND'ed unless separated by 'or'. FIELD:VALUE terms with unknown FIELD names match as\n")
+        fmt.Printf("plain STRINGs, e.g. URLs, but unknown FIELD names before '=' or '~' are an error\n")
     }
     Die(0,"")
 }
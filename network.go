@@ -0,0 +1,623 @@
+// network.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "encoding/json"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/ec2"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS ec2.Vpc type to include these additional fields
+type VpcType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.Vpc
+}
+
+// Extend AWS ec2.Subnet type to include these additional fields
+type SubnetType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.Subnet
+}
+
+// Extend AWS ec2.RouteTable type to include these additional fields
+type RouteTableType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.RouteTable
+}
+
+// Register these resource kinds with the stores
+var (
+    VpcKind        = RegisterStoreKind("VPC", VpcDataFile)
+    SubnetKind     = RegisterStoreKind("Subnet", SubnetDataFile)
+    RouteTableKind = RegisterStoreKind("Route table", RouteTableDataFile)
+)
+
+// Return string representation of this type
+func (s VpcType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *VpcType) SetAccountAlias(v string) *VpcType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *VpcType) SetAccountId(v string) *VpcType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *VpcType) SetRegion(v string) *VpcType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s SubnetType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *SubnetType) SetAccountAlias(v string) *SubnetType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *SubnetType) SetAccountId(v string) *SubnetType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *SubnetType) SetRegion(v string) *SubnetType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s RouteTableType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *RouteTableType) SetAccountAlias(v string) *RouteTableType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *RouteTableType) SetAccountId(v string) *RouteTableType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *RouteTableType) SetRegion(v string) *RouteTableType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all VPCs and their subnets with applied filter. With option -nv also display each
+// subnet's routes and the instances in it
+func ListNetworks(filter string, option string) {
+    vpcList, err := GetVpcList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    subnetList, _ := GetSubnetList()
+    rtList, _ := GetRouteTableList()
+    instList, _ := GetInstanceList()
+
+    // Index instances by subnet, so subnets can also be found by the instances in them
+    subnetInsts := map[string][]InstanceType{}
+    for _, inst := range instList {
+        if inst.SubnetId != nil {
+            subnetInsts[*inst.SubnetId] = append(subnetInsts[*inst.SubnetId], inst)
+        }
+    }
+
+    for _, vpc := range vpcList {
+        a, b, c, d, e := GetDetailsOfVpc(vpc)
+        vpcMatch := filter == "" || strContains(a, filter) || strContains(b, filter) ||
+                    strContains(c, filter) || strContains(d, filter) || strContains(e, filter)
+
+        // Show all subnets of a matching VPC, else only the matching subnets
+        var subnets []SubnetType
+        for _, subnet := range subnetList {
+            if subnet.VpcId == nil || *subnet.VpcId != a ||
+               aws.StringValue(subnet.AccountId) != aws.StringValue(vpc.AccountId) {
+                continue
+            }
+            if vpcMatch || SubnetMatches(subnet, filter, subnetInsts, rtList) {
+                subnets = append(subnets, subnet)
+            }
+        }
+        if !vpcMatch && len(subnets) == 0 {
+            continue
+        }
+
+        fmt.Printf("%-24s  %-32s  %-18s  %-18s  %s\n", a, b, c, d, e)
+        for _, subnet := range subnets {
+            id, name, cidr, az, freeIps := GetDetailsOfSubnet(subnet)
+            rt := GetRouteTableOfSubnet(subnet, rtList)
+            rtId, access := "-", "private"
+            if rt != nil {
+                rtId = aws.StringValue(rt.RouteTableId)
+                if IsPublicRouteTable(rt) { access = "public" }
+            }
+            fmt.Printf("  %-24s  %-32s  %-18s  %-14s  %5s  %4d  %-22s  %s\n",
+                id, name, cidr, az, freeIps, len(subnetInsts[id]), rtId, access)
+            if option != "-nv" {
+                continue
+            }
+            if rt != nil {
+                for _, route := range rt.Routes {
+                    if route != nil {
+                        dest, target := GetRouteDestinationAndTarget(route)
+                        fmt.Printf("    route  %-24s  %s\n", dest, target)
+                    }
+                }
+            }
+            for _, inst := range subnetInsts[id] {
+                if line, ok := GetInstanceBreakdownLine(aws.StringValue(inst.InstanceId), instList); ok {
+                    fmt.Printf("    %s\n", line)
+                }
+            }
+        }
+    }
+}
+
+
+// Check if given subnet, its route table, or any instance in it match given filter
+func SubnetMatches(subnet SubnetType, filter string, subnetInsts map[string][]InstanceType,
+                   rtList []RouteTableType) bool {
+    a, b, c, d, _ := GetDetailsOfSubnet(subnet)
+    if strContains(a, filter) || strContains(b, filter) || strContains(c, filter) ||
+       strContains(d, filter) {
+        return true
+    }
+    if rt := GetRouteTableOfSubnet(subnet, rtList); rt != nil && strContains(aws.StringValue(rt.RouteTableId), filter) {
+        return true
+    }
+    for _, inst := range subnetInsts[a] {
+        name, id, _, _, ip, _, _, _, _, _, _, _, _, _ := GetInstanceDetails(&inst)
+        if strContains(name, filter) || strContains(id, filter) || strContains(ip, filter) {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Return VPC records list from the data store
+func GetVpcList() (list []VpcType, err error) {
+    err = DataStore.Load(VpcKind, &list)
+    return list, err
+}
+
+
+// Return subnet records list from the data store
+func GetSubnetList() (list []SubnetType, err error) {
+    err = DataStore.Load(SubnetKind, &list)
+    return list, err
+}
+
+
+// Return route table records list from the data store
+func GetRouteTableList() (list []RouteTableType, err error) {
+    err = DataStore.Load(RouteTableKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfVpc(vpc VpcType) (vpcId, name, cidr, acctAlias, region string) {
+    vpcId, cidr, acctAlias, region = "-", "-", "-", "-"
+
+    if vpc.VpcId != nil { vpcId = *vpc.VpcId }
+    name = GetNameTag(vpc.Tags)
+    if vpc.IsDefault != nil && *vpc.IsDefault && name == "-" { name = "(default)" }
+    if vpc.CidrBlock != nil { cidr = *vpc.CidrBlock }
+    if vpc.AccountAlias != nil { acctAlias = *vpc.AccountAlias }
+    if vpc.Region != nil { region = *vpc.Region }
+    return
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfSubnet(subnet SubnetType) (subnetId, name, cidr, az, freeIps string) {
+    subnetId, cidr, az, freeIps = "-", "-", "-", "-"
+
+    if subnet.SubnetId != nil { subnetId = *subnet.SubnetId }
+    name = GetNameTag(subnet.Tags)
+    if subnet.CidrBlock != nil { cidr = *subnet.CidrBlock }
+    if subnet.AvailabilityZone != nil { az = *subnet.AvailabilityZone }
+    if subnet.AvailableIpAddressCount != nil {
+        freeIps = strconv.FormatInt(*subnet.AvailableIpAddressCount, 10)
+    }
+    return
+}
+
+
+// Return value of the Name tag in given tags, or '-' if there isn't one
+func GetNameTag(tags []*ec2.Tag) string {
+    for _, tag := range tags {
+        if tag != nil && tag.Key != nil && tag.Value != nil && *tag.Key == "Name" {
+            return *tag.Value
+        }
+    }
+    return "-"
+}
+
+
+// Return route table of given subnet, which is the main one of its VPC unless it has its own,
+// or nil if neither is in given list
+func GetRouteTableOfSubnet(subnet SubnetType, rtList []RouteTableType) *RouteTableType {
+    var main *RouteTableType
+    for i := range rtList {
+        rt := &rtList[i]
+        if rt.RouteTable == nil || aws.StringValue(rt.VpcId) != aws.StringValue(subnet.VpcId) {
+            continue
+        }
+        for _, assoc := range rt.Associations {
+            if assoc == nil {
+                continue
+            }
+            if assoc.SubnetId != nil && *assoc.SubnetId == aws.StringValue(subnet.SubnetId) {
+                return rt
+            }
+            if assoc.Main != nil && *assoc.Main {
+                main = rt
+            }
+        }
+    }
+    return main
+}
+
+
+// Check if given route table sends the default route to an internet gateway
+func IsPublicRouteTable(rt *RouteTableType) bool {
+    for _, route := range rt.Routes {
+        if route != nil && aws.StringValue(route.DestinationCidrBlock) == "0.0.0.0/0" &&
+           strings.HasPrefix(aws.StringValue(route.GatewayId), "igw-") {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Return destination and target of given route, as strings
+func GetRouteDestinationAndTarget(route *ec2.Route) (dest, target string) {
+    dest, target = "-", "-"
+    for _, d := range []*string{route.DestinationCidrBlock, route.DestinationIpv6CidrBlock,
+                                route.DestinationPrefixListId} {
+        if d != nil {
+            dest = *d
+            break
+        }
+    }
+    for _, t := range []*string{route.GatewayId, route.NatGatewayId, route.TransitGatewayId,
+                                route.VpcPeeringConnectionId, route.InstanceId,
+                                route.NetworkInterfaceId, route.EgressOnlyInternetGatewayId,
+                                route.LocalGatewayId, route.CarrierGatewayId} {
+        if t != nil {
+            target = *t
+            break
+        }
+    }
+    if route.State != nil && *route.State != "active" {
+        target = target + " (" + *route.State + ")"
+    }
+    return
+}
+
+
+// Return map of subnet IDs to their names, falling back to the ID for subnets without one
+func GetSubnetNameMap() map[string]string {
+    names := map[string]string{}
+    subnetList, _ := GetSubnetList()
+    for _, subnet := range subnetList {
+        if subnet.SubnetId != nil {
+            names[*subnet.SubnetId] = *subnet.SubnetId
+            if name := GetNameTag(subnet.Tags); name != "-" {
+                names[*subnet.SubnetId] = name
+            }
+        }
+    }
+    return names
+}
+
+
+// Return map of VPC IDs to their names, falling back to the ID for VPCs without one
+func GetVpcNameMap() map[string]string {
+    names := map[string]string{}
+    vpcList, _ := GetVpcList()
+    for _, vpc := range vpcList {
+        if vpc.VpcId != nil {
+            names[*vpc.VpcId] = *vpc.VpcId
+            if name := GetNameTag(vpc.Tags); name != "-" {
+                names[*vpc.VpcId] = name
+            }
+        }
+    }
+    return names
+}
+
+
+// Update local copies of VPC, subnet and route table records from given AWS accounts
+func UpdateLocalNetworkStoresFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("ec2", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local VPC, subnet and route table stores.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no EC2 events within last minutesAgo
+            fmt.Printf("Skipping local VPC, subnet and route table stores update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local VPC, subnet and route table stores (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    vpcResults := make([][]VpcType, len(sweeps))
+    vpcErrs := make([]error, len(sweeps))
+    subnetResults := make([][]SubnetType, len(sweeps))
+    subnetErrs := make([]error, len(sweeps))
+    rtResults := make([][]RouteTableType, len(sweeps))
+    rtErrs := make([]error, len(sweeps))
+    RunParallel("ec2", len(sweeps), func(i int) {
+        acct, region := sweeps[i].Account, sweeps[i].Region
+        vpcResults[i], vpcErrs[i] = GetVpcListFromAWS(acct, region)
+        subnetResults[i], subnetErrs[i] = GetSubnetListFromAWS(acct, region)
+        rtResults[i], rtErrs[i] = GetRouteTableListFromAWS(acct, region)
+    })
+
+    // Keep previous VPC records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(VpcKind.Name, sweep.Account, sweep.Region, vpcErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        // Create a new list from existing store, without the ones for the updated accounts and regions
+        var list []VpcType
+        vpcList, _ := GetVpcList()
+        for _, vpc := range vpcList {
+            if !RecordInSweep(vpc.AccountId, vpc.Region, updated) {
+                list = append(list, vpc)
+            }
+        }
+        // Now add all the new records to this new list, and make it the new local list
+        for _, result := range vpcResults {
+            list = append(list, result...)
+        }
+        WriteList(list, VpcKind)
+    }
+
+    // Same again for the subnets
+    updated = nil
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(SubnetKind.Name, sweep.Account, sweep.Region, subnetErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        var list []SubnetType
+        subnetList, _ := GetSubnetList()
+        for _, subnet := range subnetList {
+            if !RecordInSweep(subnet.AccountId, subnet.Region, updated) {
+                list = append(list, subnet)
+            }
+        }
+        for _, result := range subnetResults {
+            list = append(list, result...)
+        }
+        WriteList(list, SubnetKind)
+    }
+
+    // And for the route tables
+    updated = nil
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(RouteTableKind.Name, sweep.Account, sweep.Region, rtErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        var list []RouteTableType
+        rtList, _ := GetRouteTableList()
+        for _, rt := range rtList {
+            if !RecordInSweep(rt.AccountId, rt.Region, updated) {
+                list = append(list, rt)
+            }
+        }
+        for _, result := range rtResults {
+            list = append(list, result...)
+        }
+        WriteList(list, RouteTableKind)
+    }
+    return
+}
+
+
+// Return all VPC objects in given account and region
+func GetVpcListFromAWS(acct *AccountType, region string) (list []VpcType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    params := &ec2.DescribeVpcsInput{
+        MaxResults: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxResults records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeVpcsOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeVpcs(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.Vpcs != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.Vpcs)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var vpcList []VpcType
+            err = json.Unmarshal(jsonData, &vpcList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, vpc := range vpcList {
+                // Add our additional fields
+                vpc = *vpc.SetAccountAlias(acct.Alias)
+                vpc = *vpc.SetAccountId(acct.Id)
+                vpc = *vpc.SetRegion(region)
+                list = append(list, vpc)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
+
+
+// Return all subnet objects in given account and region
+func GetSubnetListFromAWS(acct *AccountType, region string) (list []SubnetType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    params := &ec2.DescribeSubnetsInput{
+        MaxResults: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxResults records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeSubnetsOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeSubnets(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.Subnets != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.Subnets)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var subnetList []SubnetType
+            err = json.Unmarshal(jsonData, &subnetList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, subnet := range subnetList {
+                // Add our additional fields
+                subnet = *subnet.SetAccountAlias(acct.Alias)
+                subnet = *subnet.SetAccountId(acct.Id)
+                subnet = *subnet.SetRegion(region)
+                list = append(list, subnet)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
+
+
+// Return all route table objects in given account and region
+func GetRouteTableListFromAWS(acct *AccountType, region string) (list []RouteTableType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    params := &ec2.DescribeRouteTablesInput{
+        MaxResults: aws.Int64(100),  // 100 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxResults records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeRouteTablesOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeRouteTables(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.RouteTables != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.RouteTables)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var rtList []RouteTableType
+            err = json.Unmarshal(jsonData, &rtList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, rt := range rtList {
+                // Add our additional fields
+                rt = *rt.SetAccountAlias(acct.Alias)
+                rt = *rt.SetAccountId(acct.Id)
+                rt = *rt.SetRegion(region)
+                list = append(list, rt)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
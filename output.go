@@ -0,0 +1,172 @@
+// output.go
+package main
+
+import (
+    "fmt"
+    "os"
+    "bytes"
+    "strings"
+    "strconv"
+    "encoding/csv"
+    "encoding/json"
+)
+
+// Output formats for listings, besides the default table format
+var OutputFormats = []string{"json", "ndjson", "csv", "tsv", "yaml"}
+
+// Output format given with -o or --output, empty meaning the table format
+var OutputFormat = ""
+
+// A listing's rows, kept until they're all in so they can be output in any format
+type ListingType struct {
+    Fields  []string     // Keys of each row's fields, in column order
+    Rows    []*RowType
+}
+
+// A listing row
+type RowType struct {
+    Fields  FieldList
+    Record  interface{}  // The store record behind this row, for --columns and --template
+    Print   func()       // Prints this row in the table format
+    Groups  []string     // Values of the --group keys, once sorted
+}
+
+
+// Set output format to given one, aborting if it isn't known
+func SetOutputFormat(format string) {
+    format = strings.ToLower(format)
+    if !strInList(format, OutputFormats) {
+        Die(1, "Error. Unknown output format '" + format + "'. Formats are: " +
+            strings.Join(OutputFormats, ", "))
+    }
+    OutputFormat = format
+}
+
+
+// Return new listing with rows of given fields
+func NewListing(fields []string) *ListingType {
+    return &ListingType{Fields: fields}
+}
+
+
+// Add row of given fields and store record to this listing, with the function that prints it
+// as a table row
+func (l *ListingType) Add(fields FieldList, record interface{}, print func()) {
+    l.Rows = append(l.Rows, &RowType{Fields: fields, Record: record, Print: print})
+}
+
+
+// Output all rows of this listing in the selected order and format, columns or template, or
+// just their counts
+func (l *ListingType) Flush() {
+    defer func() { l.Rows = nil }()
+    l.SortRows()
+    if OutputCount {
+        l.PrintCounts()
+        return
+    }
+    if OutputTemplate != nil {
+        l.PrintTemplate()
+        return
+    }
+    if OutputColumns != nil {
+        l.SelectColumns()
+    }
+    if OutputFormat == "" {
+        l.PrintTable()
+        return
+    }
+    l.Encode()
+}
+
+
+// Print all rows of this listing in the table format, under the headers of their groups
+func (l *ListingType) PrintTable() {
+    print := func(row *RowType) { row.Print() }
+    if OutputColumns != nil {
+        print = l.ColumnPrinter()
+    }
+    counts := l.GetGroupCounts()
+    var prev []string
+    for _, row := range l.Rows {
+        PrintGroupHeaders(prev, row.Groups, counts)
+        prev = row.Groups
+        print(row)
+    }
+    if OutputGroups != nil {
+        fmt.Printf("total  %d\n", len(l.Rows))
+    }
+}
+
+
+// Output all rows of this listing in the selected output format
+func (l *ListingType) Encode() {
+    switch OutputFormat {
+    case "json":
+        if len(l.Rows) == 0 {
+            fmt.Println("[]")
+            break
+        }
+        fmt.Println("[")
+        for i, row := range l.Rows {
+            sep := ","
+            if i == len(l.Rows) - 1 { sep = "" }
+            fmt.Printf("  %s%s\n", row.Fields.JSON(), sep)
+        }
+        fmt.Println("]")
+    case "ndjson":
+        for _, row := range l.Rows {
+            fmt.Println(row.Fields.JSON())
+        }
+    case "csv", "tsv":
+        w := csv.NewWriter(os.Stdout)
+        if OutputFormat == "tsv" { w.Comma = '\t' }
+        w.Write(l.Fields)
+        for _, row := range l.Rows {
+            values := make([]string, len(row.Fields))
+            for i, f := range row.Fields {
+                if !IsMissingValue(f.Value) { values[i] = f.Value }
+            }
+            w.Write(values)
+        }
+        w.Flush()
+    case "yaml":
+        if len(l.Rows) == 0 {
+            fmt.Println("[]")
+            break
+        }
+        for _, row := range l.Rows {
+            // Double-quoted YAML strings take the same escapes as Go ones
+            for i, f := range row.Fields {
+                prefix, value := "  ", "null"
+                if i == 0 { prefix = "- " }
+                if !IsMissingValue(f.Value) { value = strconv.Quote(f.Value) }
+                fmt.Printf("%s%s: %s\n", prefix, f.Name, value)
+            }
+        }
+    }
+}
+
+
+// Check if given field value is missing, whether empty or the '-' the tables show instead
+func IsMissingValue(value string) bool {
+    return value == "" || value == "-"
+}
+
+
+// Return these fields as a JSON object, keeping them in column order, with missing values null
+func (l FieldList) JSON() string {
+    var buf bytes.Buffer
+    buf.WriteString("{")
+    for i, f := range l {
+        if i > 0 { buf.WriteString(", ") }
+        name, _ := json.Marshal(f.Name)
+        value := []byte("null")
+        if !IsMissingValue(f.Value) { value, _ = json.Marshal(f.Value) }
+        buf.Write(name)
+        buf.WriteString(": ")
+        buf.Write(value)
+    }
+    buf.WriteString("}")
+    return buf.String()
+}
@@ -0,0 +1,89 @@
+// pool.go
+package main
+
+import (
+    "sync"
+    "time"
+)
+
+
+// Worker pool limits. Below hard-coded values can be overriden via $HOME/.awsinfo/config
+var (
+    MaxWorkers      = 8                                  // Max concurrent AWS collectors overall
+    ServiceWorkers  = map[string]int{"route53": 4}       // Max concurrent collectors per service
+    ServiceRates    = map[string]int{"route53": 5}       // Max API requests per second per service
+)
+
+// Worker slots and rate tickers, created on first use
+var (
+    poolMutex       sync.Mutex
+    globalSlots     chan bool
+    serviceSlots    = map[string]chan bool{}
+    serviceTickers  = map[string]*time.Ticker{}
+)
+
+
+// Call fn for each index from 0 to count-1 concurrently, limited by the global and the given
+// service's worker pools, and return once they're all done
+func RunParallel(service string, count int, fn func(i int)) {
+    var wg sync.WaitGroup
+    for i := 0 ; i < count ; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            global, local := GetWorkerSlots(service)
+            global <- true
+            defer func() { <-global }()
+            if local != nil {
+                local <- true
+                defer func() { <-local }()
+            }
+            fn(i)
+        }(i)
+    }
+    wg.Wait()
+}
+
+
+// Return global worker slots and those for given service, which are nil if it has no limit
+func GetWorkerSlots(service string) (global, local chan bool) {
+    poolMutex.Lock()
+    defer poolMutex.Unlock()
+    if globalSlots == nil {
+        globalSlots = make(chan bool, maxInt(MaxWorkers, 1))
+    }
+    if _, ok := serviceSlots[service]; !ok {
+        if limit, ok := ServiceWorkers[service]; ok && limit > 0 {
+            serviceSlots[service] = make(chan bool, limit)
+        } else {
+            serviceSlots[service] = nil
+        }
+    }
+    return globalSlots, serviceSlots[service]
+}
+
+
+// Block until given service's rate limit allows another API request
+func WaitForRate(service string) {
+    poolMutex.Lock()
+    ticker, ok := serviceTickers[service]
+    if !ok {
+        if rate, ok := ServiceRates[service]; ok && rate > 0 {
+            ticker = time.NewTicker(time.Second / time.Duration(rate))
+        }
+        serviceTickers[service] = ticker
+    }
+    poolMutex.Unlock()
+    if ticker != nil {
+        <-ticker.C
+    }
+}
+
+
+// Return the larger of two integers
+func maxInt(a, b int) int {
+    if a > b {
+        return a
+    }
+    return b
+}
@@ -0,0 +1,230 @@
+// query.go
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+// A named field of a listed record, e.g. 'state' with value 'running'
+type FieldType struct {
+    Name   string
+    Value  string
+}
+
+// A listed record's fields, in display order
+type FieldList []FieldType
+
+// A list filter, parsed into terms that are AND'ed within each group, with the groups OR'ed.
+// A filter with no field terms behaves like the plain substring filters always have
+type QueryType struct {
+    Groups  [][]*QueryTermType
+}
+
+// A single query term, e.g. 'state=running', 'type~^m5', '!name:bastion' or just 'prod'
+type QueryTermType struct {
+    Field   string           // Empty for plain terms, which match any field
+    Op      byte             // '=' equals, '~' matches regex, ':' contains
+    Value   string
+    Regexp  *regexp.Regexp   // Compiled Value, for '~' terms
+    Negate  bool
+}
+
+
+// Return new field list from given field names and their values, which must match in number
+func NewFieldList(names []string, values ...string) FieldList {
+    if len(names) != len(values) {
+        panic(fmt.Sprintf("Error. %d field names for %d values", len(names), len(values)))
+    }
+    list := make(FieldList, len(names))
+    for i := range names {
+        list[i] = FieldType{Name: names[i], Value: values[i]}
+    }
+    return list
+}
+
+
+// Return value of given field, and whether there's such a field
+func (l FieldList) Get(name string) (string, bool) {
+    for _, f := range l {
+        if strings.EqualFold(f.Name, name) {
+            return f.Value, true
+        }
+    }
+    return "", false
+}
+
+
+// Parse given filter into a query, aborting with an error if it isn't valid. Terms only match
+// a field if the name before their operator is one of given ones, else they're plain terms, so
+// URLs, ARNs and HOST:PORT still work as filters. A plain name before '=' or '~' that isn't one
+// of given ones is taken as a mistyped field though, and is an error
+func ParseQueryOrDie(filter string, fieldNames []string) *QueryType {
+    query, err := ParseQuery(filter, fieldNames)
+    if err != nil {
+        Die(1, "Error. " + err.Error())
+    }
+    return query
+}
+
+
+// Parse given filter into a query. Terms are separated by spaces, and are AND'ed unless
+// separated by 'or'. Values with spaces can be quoted
+func ParseQuery(filter string, fieldNames []string) (*QueryType, error) {
+    words, err := SplitQueryWords(filter)
+    if err != nil {
+        return nil, err
+    }
+    query := &QueryType{}
+    var group []*QueryTermType
+    for _, word := range words {
+        switch strings.ToLower(word) {
+        case "and", "&&":
+            continue   // Terms are AND'ed anyway
+        case "or", "||":
+            if len(group) == 0 {
+                return nil, fmt.Errorf("'%s' with no term before it", word)
+            }
+            query.Groups = append(query.Groups, group)
+            group = nil
+            continue
+        }
+        term, err := ParseQueryTerm(word, fieldNames)
+        if err != nil {
+            return nil, err
+        }
+        group = append(group, term)
+    }
+    if len(group) > 0 {
+        query.Groups = append(query.Groups, group)
+    } else if len(query.Groups) > 0 {
+        return nil, fmt.Errorf("'or' with no term after it")
+    }
+    return query, nil
+}
+
+
+// Parse given word into a query term
+func ParseQueryTerm(word string, fieldNames []string) (*QueryTermType, error) {
+    term := &QueryTermType{}
+    if strings.HasPrefix(word, "!") && len(word) > 1 {
+        term.Negate = true
+        word = word[1:]
+    }
+    term.Value = word
+
+    // Look for the first operator that follows a field name. Anything else is a plain term
+    for i := 0 ; i < len(word) ; i++ {
+        op := word[i]
+        if op != '=' && op != '~' && op != ':' {
+            continue
+        }
+        name := word[:i]
+        if strInList(name, fieldNames) {
+            term.Field, term.Op, term.Value = strings.ToLower(name), op, word[i+1:]
+            break
+        }
+        if op != ':' && IsIdentifier(name) {
+            return nil, fmt.Errorf("unknown field '%s' in '%s'. Fields are: %s. Use FIELD:VALUE " +
+                "to look for values with '=' or '~' in them", name, word, strings.Join(fieldNames, ", "))
+        }
+    }
+
+    if term.Op == '~' {
+        re, err := regexp.Compile("(?i)" + term.Value)
+        if err != nil {
+            return nil, fmt.Errorf("invalid regex in '%s': %s", word, err.Error())
+        }
+        term.Regexp = re
+    }
+    return term, nil
+}
+
+
+// Check if given string is a plain name, i.e., a letter followed by letters, digits or '_'
+func IsIdentifier(s string) bool {
+    for i, r := range s {
+        if !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r) && r != '_') {
+            return false
+        }
+    }
+    return s != ""
+}
+
+
+// Split given filter into words at spaces, keeping single or double quoted strings together
+func SplitQueryWords(filter string) (words []string, err error) {
+    var word strings.Builder
+    inWord := false
+    var quote rune
+    for _, r := range filter {
+        switch {
+        case quote != 0 && r == quote:
+            quote = 0
+        case quote != 0:
+            word.WriteRune(r)
+        case r == '"' || r == '\'':
+            quote, inWord = r, true
+        case unicode.IsSpace(r):
+            if inWord {
+                words = append(words, word.String())
+                word.Reset()
+                inWord = false
+            }
+        default:
+            word.WriteRune(r)
+            inWord = true
+        }
+    }
+    if quote != 0 {
+        return nil, fmt.Errorf("unterminated quote in '%s'", filter)
+    }
+    if inWord {
+        words = append(words, word.String())
+    }
+    return words, nil
+}
+
+
+// Check if given record fields match this query. Empty queries match everything
+func (q *QueryType) Match(fields FieldList) bool {
+    if q == nil || len(q.Groups) == 0 {
+        return true
+    }
+    for _, group := range q.Groups {
+        matched := true
+        for _, term := range group {
+            if term.Match(fields) == term.Negate {
+                matched = false
+                break
+            }
+        }
+        if matched {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Check if given record fields match this term, ignoring its negation
+func (t *QueryTermType) Match(fields FieldList) bool {
+    if t.Field == "" {
+        for _, f := range fields {
+            if strContains(f.Value, t.Value) {
+                return true
+            }
+        }
+        return false
+    }
+    value, _ := fields.Get(t.Field)
+    switch t.Op {
+    case '=':
+        return strings.EqualFold(value, t.Value)
+    case '~':
+        return t.Regexp.MatchString(value)
+    }
+    return strContains(value, t.Value)
+}
@@ -0,0 +1,447 @@
+// rds.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "encoding/json"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/rds"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS rds.DBInstance type to include these additional fields
+type DBInstanceType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *rds.DBInstance
+}
+
+// Extend AWS rds.DBCluster type to include these additional fields
+type DBClusterType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *rds.DBCluster
+}
+
+// Register these resource kinds with the stores
+var (
+    DBInstanceKind = RegisterStoreKind("RDS instance", DBInstanceDataFile)
+    DBClusterKind  = RegisterStoreKind("RDS cluster", DBClusterDataFile)
+)
+
+// Register RDS endpoints with the DNS breakdown. They're always the end of the line
+var RDSTarget = RegisterDNSTarget("RDS", IsRDSEndpoint, func(dnsName string) []string {
+    BreakdownRDS(dnsName)
+    return nil
+})
+
+// Return string representation of this type
+func (s DBInstanceType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *DBInstanceType) SetAccountAlias(v string) *DBInstanceType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *DBInstanceType) SetAccountId(v string) *DBInstanceType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *DBInstanceType) SetRegion(v string) *DBInstanceType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s DBClusterType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *DBClusterType) SetAccountAlias(v string) *DBClusterType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *DBClusterType) SetAccountId(v string) *DBClusterType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *DBClusterType) SetRegion(v string) *DBClusterType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all RDS clusters and instances with applied filter
+func ListRDS(filter string) {
+    clusterList, err := GetDBClusterList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    instList, err := GetDBInstanceList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    for _, c := range clusterList {
+        a, b, d, e, f, g, h, k := GetDetailsOfDBCluster(c)
+        reader := "-"
+        if c.ReaderEndpoint != nil { reader = *c.ReaderEndpoint }
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(d, filter) || strContains(e, filter) || strContains(f, filter) ||
+           strContains(g, filter) || strContains(h, filter) || strContains(k, filter) ||
+           strContains(reader, filter) {
+            fmt.Printf("%-40s  %-8s  %-24s  %-16s  %-8s  %-12s  %-18s  %-14s  %s\n",
+                a, "cluster", b, "-", d, e, f, g, h)
+        }
+    }
+    for _, i := range instList {
+        a, b, c, d, e, f, g, h, k := GetDetailsOfDBInstance(i)
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(f, filter) || strContains(g, filter) || strContains(h, filter) ||
+           strContains(k, filter) {
+            fmt.Printf("%-40s  %-8s  %-24s  %-16s  %-8s  %-12s  %-18s  %-14s  %s\n",
+                a, "instance", b, c, d, e, f, g, h)
+        }
+    }
+}
+
+
+// Return RDS instance records list from the data store
+func GetDBInstanceList() (list []DBInstanceType, err error) {
+    err = DataStore.Load(DBInstanceKind, &list)
+    return list, err
+}
+
+
+// Return RDS cluster records list from the data store
+func GetDBClusterList() (list []DBClusterType, err error) {
+    err = DataStore.Load(DBClusterKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfDBInstance(i DBInstanceType) (Id string,              // a
+                                               Engine string,          // b
+                                               Class string,           // c
+                                               MultiAZ string,         // d
+                                               Status string,          // e
+                                               AccountAlias string,    // f
+                                               Region string,          // g
+                                               Endpoint string,        // h
+                                               ClusterId string) {     // k
+    Id, Engine, Class, MultiAZ, Status = "-", "-", "-", "-", "-"
+    AccountAlias, Region, Endpoint, ClusterId = "-", "-", "-", "-"
+
+    if i.DBInstanceIdentifier != nil { Id = *i.DBInstanceIdentifier }
+    if i.Engine != nil { Engine = *i.Engine }
+    if i.EngineVersion != nil { Engine = Engine + " " + *i.EngineVersion }
+    if i.DBInstanceClass != nil { Class = *i.DBInstanceClass }
+    if i.MultiAZ != nil && *i.MultiAZ { MultiAZ = "multi-az" }
+    if i.DBInstanceStatus != nil { Status = *i.DBInstanceStatus }
+    if i.AccountAlias != nil { AccountAlias = *i.AccountAlias }
+    if i.Region != nil { Region = *i.Region }
+    if i.Endpoint != nil && i.Endpoint.Address != nil {
+        Endpoint = *i.Endpoint.Address
+        if i.Endpoint.Port != nil { Endpoint = fmt.Sprintf("%s:%d", Endpoint, *i.Endpoint.Port) }
+    }
+    if i.DBClusterIdentifier != nil { ClusterId = *i.DBClusterIdentifier }
+    return
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfDBCluster(c DBClusterType) (Id string,              // a
+                                             Engine string,          // b
+                                             MultiAZ string,         // d
+                                             Status string,          // e
+                                             AccountAlias string,    // f
+                                             Region string,          // g
+                                             Endpoint string,        // h
+                                             Members string) {       // k
+    Id, Engine, MultiAZ, Status = "-", "-", "-", "-"
+    AccountAlias, Region, Endpoint, Members = "-", "-", "-", "-"
+
+    if c.DBClusterIdentifier != nil { Id = *c.DBClusterIdentifier }
+    if c.Engine != nil { Engine = *c.Engine }
+    if c.EngineVersion != nil { Engine = Engine + " " + *c.EngineVersion }
+    if c.MultiAZ != nil && *c.MultiAZ { MultiAZ = "multi-az" }
+    if c.Status != nil { Status = *c.Status }
+    if c.AccountAlias != nil { AccountAlias = *c.AccountAlias }
+    if c.Region != nil { Region = *c.Region }
+    if c.Endpoint != nil {
+        Endpoint = *c.Endpoint
+        if c.Port != nil { Endpoint = fmt.Sprintf("%s:%d", Endpoint, *c.Port) }
+    }
+    var ids []string
+    for _, m := range c.DBClusterMembers {
+        if m != nil && m.DBInstanceIdentifier != nil {
+            ids = append(ids, *m.DBInstanceIdentifier)
+        }
+    }
+    if len(ids) > 0 { Members = strings.Join(ids, " ") }
+    return
+}
+
+
+// Check if given DNS name is an RDS endpoint, whether or not it's in our stores
+func IsRDSEndpoint(dnsName string) bool {
+    return strings.HasSuffix(strings.ToLower(NormalDNSName(dnsName)), ".rds.amazonaws.com")
+}
+
+
+// Breakdown given RDS endpoint into its cluster and/or instances
+func BreakdownRDS(dnsName string) {
+    fmt.Println(dnsName)
+    instList, _ := GetDBInstanceList()
+
+    // Cluster endpoints, including reader and custom ones, break down into their members
+    clusterList, _ := GetDBClusterList()
+    for _, c := range clusterList {
+        endpoints := aws.StringValueSlice(c.CustomEndpoints)
+        endpoints = append(endpoints, aws.StringValue(c.Endpoint), aws.StringValue(c.ReaderEndpoint))
+        if !strInList(NormalDNSName(dnsName), endpoints) {
+            continue
+        }
+        a, b, d, e, f, g, _, _ := GetDetailsOfDBCluster(c)
+        fmt.Printf("  cluster   %-40s  %-24s  %-8s  %-12s  %-18s  %s\n", a, b, d, e, f, g)
+        for _, m := range c.DBClusterMembers {
+            if m == nil || m.DBInstanceIdentifier == nil {
+                continue
+            }
+            role := "reader"
+            if m.IsClusterWriter != nil && *m.IsClusterWriter { role = "writer" }
+            inst := GetDBInstanceById(*m.DBInstanceIdentifier, c.AccountId, instList)
+            if inst == nil {
+                fmt.Printf("    %-6s  %s not found in RDS instance store\n", role, *m.DBInstanceIdentifier)
+                continue
+            }
+            id, _, class, _, status, _, _, _, _ := GetDetailsOfDBInstance(*inst)
+            fmt.Printf("    %-6s  %-40s  %-16s  %s\n", role, id, class, status)
+        }
+        return
+    }
+
+    // Otherwise it should be an instance endpoint
+    for _, i := range instList {
+        if i.Endpoint == nil || i.Endpoint.Address == nil ||
+           !strings.EqualFold(NormalDNSName(dnsName), *i.Endpoint.Address) {
+            continue
+        }
+        a, b, c, d, e, f, g, _, _ := GetDetailsOfDBInstance(i)
+        fmt.Printf("  instance  %-40s  %-24s  %-16s  %-8s  %-12s  %-18s  %s\n", a, b, c, d, e, f, g)
+        return
+    }
+    fmt.Printf("  Not found in RDS stores\n")
+}
+
+
+// Return RDS instance with given identifier in given account, or nil if it's not in given list
+func GetDBInstanceById(id string, accountId *string, instList []DBInstanceType) *DBInstanceType {
+    for x := range instList {
+        i := &instList[x]
+        if i.DBInstanceIdentifier != nil && *i.DBInstanceIdentifier == id &&
+           (accountId == nil || i.AccountId == nil || *i.AccountId == *accountId) {
+            return i
+        }
+    }
+    return nil
+}
+
+
+// Update local copies of RDS instance and cluster records from given AWS accounts
+func UpdateLocalRDSStoresFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("rds", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local RDS stores.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip RDS update if no RDS events within last minutesAgo
+            fmt.Printf("Skipping local RDS stores update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local RDS stores (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    instResults := make([][]DBInstanceType, len(sweeps))
+    instErrs := make([]error, len(sweeps))
+    clusterResults := make([][]DBClusterType, len(sweeps))
+    clusterErrs := make([]error, len(sweeps))
+    RunParallel("rds", len(sweeps), func(i int) {
+        instResults[i], instErrs[i] = GetDBInstanceListFromAWS(sweeps[i].Account, sweeps[i].Region)
+        clusterResults[i], clusterErrs[i] = GetDBClusterListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous instance records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(DBInstanceKind.Name, sweep.Account, sweep.Region, instErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        // Create a new list from existing store, without the ones for the updated accounts and regions
+        var list []DBInstanceType
+        instList, _ := GetDBInstanceList()
+        for _, inst := range instList {
+            if !RecordInSweep(inst.AccountId, inst.Region, updated) {
+                list = append(list, inst)
+            }
+        }
+        // Now add all the new records to this new list, and make it the new local list
+        for _, result := range instResults {
+            list = append(list, result...)
+        }
+        WriteList(list, DBInstanceKind)
+    }
+
+    // Same again for the clusters
+    updated = nil
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(DBClusterKind.Name, sweep.Account, sweep.Region, clusterErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        var list []DBClusterType
+        clusterList, _ := GetDBClusterList()
+        for _, cluster := range clusterList {
+            if !RecordInSweep(cluster.AccountId, cluster.Region, updated) {
+                list = append(list, cluster)
+            }
+        }
+        for _, result := range clusterResults {
+            list = append(list, result...)
+        }
+        WriteList(list, DBClusterKind)
+    }
+    return
+}
+
+
+// Return all RDS instance objects in given account and region
+func GetDBInstanceListFromAWS(acct *AccountType, region string) (list []DBInstanceType, err error) {
+    svc := rds.New(acct.Sess, AWSConfig(region))
+
+    params := &rds.DescribeDBInstancesInput{
+        MaxRecords: aws.Int64(100),  // 100 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxRecords records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *rds.DescribeDBInstancesOutput
+        err := CallWithRetry("rds", func() (err error) {
+            resp, err = svc.DescribeDBInstances(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.DBInstances != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.DBInstances)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var instList []DBInstanceType
+            err = json.Unmarshal(jsonData, &instList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, inst := range instList {
+                // Add our additional fields
+                inst = *inst.SetAccountAlias(acct.Alias)
+                inst = *inst.SetAccountId(acct.Id)
+                inst = *inst.SetRegion(region)
+                list = append(list, inst)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.Marker == nil {
+            break
+        } else {
+            params.Marker = resp.Marker
+        }
+    }
+    return list, nil
+}
+
+
+// Return all RDS cluster objects in given account and region
+func GetDBClusterListFromAWS(acct *AccountType, region string) (list []DBClusterType, err error) {
+    svc := rds.New(acct.Sess, AWSConfig(region))
+
+    params := &rds.DescribeDBClustersInput{
+        MaxRecords: aws.Int64(100),  // 100 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxRecords records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *rds.DescribeDBClustersOutput
+        err := CallWithRetry("rds", func() (err error) {
+            resp, err = svc.DescribeDBClusters(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.DBClusters != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.DBClusters)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var clusterList []DBClusterType
+            err = json.Unmarshal(jsonData, &clusterList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, cluster := range clusterList {
+                // Add our additional fields
+                cluster = *cluster.SetAccountAlias(acct.Alias)
+                cluster = *cluster.SetAccountId(acct.Id)
+                cluster = *cluster.SetRegion(region)
+                list = append(list, cluster)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.Marker == nil {
+            break
+        } else {
+            params.Marker = resp.Marker
+        }
+    }
+    return list, nil
+}
@@ -0,0 +1,58 @@
+// region.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "github.com/aws/aws-sdk-go/service/ec2"
+)
+
+
+// Return list of regions to sweep in given account, as per the regions setting in the config file
+func GetAWSRegionList(acct *AccountType) (list []string) {
+    regionConf := AWSRegions
+    if acct.RegionConf != "" {
+        regionConf = acct.RegionConf
+    }
+
+    // Default to the single region from the usual AWS environment variables and config file
+    if regionConf == "" {
+        return []string{AWSRegion}
+    }
+
+    // Keyword 'all' means every region that's enabled for this account
+    if strings.EqualFold(regionConf, "all") {
+        svc := ec2.New(acct.Sess, AWSConfig(AWSRegion))
+        var resp *ec2.DescribeRegionsOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeRegions(&ec2.DescribeRegionsInput{})
+            return err
+        })
+        if err != nil {
+            // Fall back to the default region, reporting the account as partially failed, since
+            // all its other regions are skipped
+            UpdateReport.AddError(&CollectError{Service: "ec2", Account: acct, Scope: "regions",
+                Err: fmt.Errorf("unable to list regions, only sweeping %s: %s", AWSRegion, err.Error())})
+            return []string{AWSRegion}
+        }
+        for _, region := range resp.Regions {
+            if region.RegionName != nil {
+                list = append(list, *region.RegionName)
+            }
+        }
+        return list
+    }
+
+    // Else, it's a comma-separated list of regions
+    for _, region := range strings.Split(regionConf, ",") {
+        region = strings.TrimSpace(region)
+        if region != "" {
+            list = AppendIfMissing(list, region)
+        }
+    }
+    if len(list) == 0 {
+        Die(1, "Error. No valid regions in regions setting: " + regionConf)
+    }
+    return list
+}
+
@@ -0,0 +1,115 @@
+// report.go
+package main
+
+import (
+    "fmt"
+    "sync"
+)
+
+
+// Exit codes of an update run
+const (
+    ExitPartialFailure = 2   // Some collectors failed, the rest updated their stores
+    ExitTotalFailure   = 3   // Nothing at all could be updated
+)
+
+
+// Error from collecting the records of a service in an account and region
+type CollectError struct {
+    Service  string
+    Account  *AccountType   // Nil if error isn't specific to an account
+    Scope    string         // Region, zone, etc. Empty if error isn't specific to one
+    Err      error
+}
+
+// Return string representation of this error
+func (e *CollectError) Error() string {
+    where := e.Service
+    if e.Account != nil {
+        where += " " + e.Account.Alias
+    }
+    if e.Scope != "" {
+        where += " " + e.Scope
+    }
+    return where + ": " + e.Err.Error()
+}
+
+
+// Outcome of all collectors in an update run
+type UpdateReportType struct {
+    mutex      sync.Mutex
+    Successes  int
+    Errors     []*CollectError
+}
+
+// The report for the current update run
+var UpdateReport UpdateReportType
+
+
+// Record a successful collection
+func (r *UpdateReportType) AddSuccess() {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    r.Successes++
+}
+
+// Record a failed collection
+func (r *UpdateReportType) AddError(err *CollectError) {
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    r.Errors = append(r.Errors, err)
+    fmt.Printf("  Error: %s\n", err.Error())
+}
+
+// Record outcome of a collection, returning true if it was successful
+func (r *UpdateReportType) Add(service string, acct *AccountType, scope string, err error) bool {
+    if err != nil {
+        r.AddError(&CollectError{Service: service, Account: acct, Scope: scope, Err: err})
+        return false
+    }
+    r.AddSuccess()
+    return true
+}
+
+
+// Print sweep outcome of each given account, followed by all errors, and return the exit code
+func PrintUpdateReport(accounts []*AccountType) int {
+    fmt.Printf("Account summary:\n")
+    failedAccounts := 0
+    for _, acct := range accounts {
+        if acct.Err != nil {
+            failedAccounts++
+            fmt.Printf("  %-24s  %-12s  FAILED: %s\n", acct.Name, "-", acct.Err.Error())
+            continue
+        }
+        errCount := 0
+        for _, err := range UpdateReport.Errors {
+            if err.Account == acct {
+                errCount++
+            }
+        }
+        if errCount > 0 {
+            fmt.Printf("  %-24s  %-12s  PARTIAL: %d errors\n", acct.Alias, acct.Id, errCount)
+        } else {
+            fmt.Printf("  %-24s  %-12s  OK\n", acct.Alias, acct.Id)
+        }
+    }
+
+    if len(UpdateReport.Errors) > 0 {
+        fmt.Printf("Errors (previous records were kept for these):\n")
+        for _, err := range UpdateReport.Errors {
+            fmt.Printf("  %s\n", err.Error())
+        }
+    }
+
+    PrintRetryCounts()
+    if failedAccounts == 0 && len(UpdateReport.Errors) == 0 {
+        return 0
+    }
+    if UpdateReport.Successes == 0 {
+        fmt.Printf("Update failed.\n")
+        return ExitTotalFailure
+    }
+    fmt.Printf("Update partially failed.\n")
+    return ExitPartialFailure
+}
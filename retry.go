@@ -0,0 +1,182 @@
+// retry.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+    "math/rand"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+
+// How to retry a failed AWS API call
+type RetryPolicy struct {
+    BaseDelay    time.Duration   // Delay before the first retry, doubled for each one after that
+    MaxDelay     time.Duration   // Cap on any single delay
+    MaxAttempts  int             // Max number of calls, including the first one
+    MaxElapsed   time.Duration   // Give up once this much time has gone by since the first call
+}
+
+// Retry policies. These get set up from the config file settings in SetupRetryPolicies()
+var (
+    DefaultRetryPolicy     RetryPolicy
+    ServiceRetryPolicies   = map[string]RetryPolicy{}
+)
+
+// Below hard-coded values can be overriden via $HOME/.awsinfo/config
+var (
+    APIMaxAttempts       = 10
+    APIMaxElapsedSeconds = 600
+)
+
+// AWS error codes meaning we're being throttled
+var throttleErrorCodes = []string{
+    "Throttling", "ThrottlingException", "ThrottledException", "RequestThrottledException",
+    "TooManyRequestsException", "RequestLimitExceeded", "RequestThrottled", "SlowDown",
+    "PriorRequestNotComplete", "EC2ThrottledException", "BandwidthLimitExceeded",
+    "ProvisionedThroughputExceededException", "TransactionInProgressException",
+}
+
+// AWS error codes meaning the call may well work if tried again
+var transientErrorCodes = []string{
+    "RequestError", "RequestTimeout", "RequestTimeoutException", "InternalError",
+    "InternalFailure", "ServiceUnavailable", "ServiceUnavailableException",
+}
+
+// Count of retries per service
+var (
+    retryMutex   sync.Mutex
+    RetryCounts  = map[string]int{}
+)
+
+
+// Set up the retry policies as per configuration
+func SetupRetryPolicies() {
+    DefaultRetryPolicy = RetryPolicy{
+        BaseDelay:   time.Duration(maxInt(APISecondsDelay, 1)) * time.Second,
+        MaxDelay:    60 * time.Second,
+        MaxAttempts: APIMaxAttempts,
+        MaxElapsed:  time.Duration(APIMaxElapsedSeconds) * time.Second,
+    }
+    // Route53 throttling lasts a lot longer, so allow for much longer delays
+    route53Policy := DefaultRetryPolicy
+    route53Policy.MaxDelay = time.Duration(maxInt(R53APISecondsDelay, 1)) * time.Second
+    ServiceRetryPolicies["route53"] = route53Policy
+}
+
+
+// Return retry policy for given service
+func GetRetryPolicy(service string) RetryPolicy {
+    if policy, ok := ServiceRetryPolicies[service]; ok {
+        return policy
+    }
+    return DefaultRetryPolicy
+}
+
+
+// Return delay before given retry number, starting at 1, with exponential backoff and jitter
+func (p RetryPolicy) Delay(retry int) time.Duration {
+    delay := p.BaseDelay
+    for i := 1 ; i < retry && delay < p.MaxDelay ; i++ {
+        delay *= 2
+    }
+    if delay > p.MaxDelay {
+        delay = p.MaxDelay
+    }
+    // Use anything between half and all of it, so concurrent callers don't retry in lockstep
+    half := int64(delay / 2)
+    return time.Duration(half + rand.Int63n(half + 1))
+}
+
+
+// Call given AWS API function, with the given service's rate limit, retrying any throttled or
+// transient failures as per the service's retry policy
+func CallWithRetry(service string, call func() error) (err error) {
+    policy := GetRetryPolicy(service)
+    start := time.Now()
+    for attempt := 1 ; ; attempt++ {
+        WaitForRate(service)
+        err = call()
+        if err == nil || !IsRetryableError(err) {
+            return err
+        }
+        delay := policy.Delay(attempt)
+        if attempt >= policy.MaxAttempts || time.Since(start) + delay > policy.MaxElapsed {
+            return err   // Retry budget is spent
+        }
+        if IsThrottleError(err) {
+            fmt.Printf("  AWS throttling %s. Sleeping %.1f seconds...\n", service, delay.Seconds())
+        }
+        AddRetryCount(service)
+        time.Sleep(delay)
+    }
+}
+
+
+// Check if given error is AWS throttling us
+func IsThrottleError(err error) bool {
+    if aerr, ok := err.(awserr.Error); ok {
+        return strInList(aerr.Code(), throttleErrorCodes)
+    }
+    return false
+}
+
+
+// Check if given error is an AWS error with any of given codes
+func IsErrorCode(err error, codes ...string) bool {
+    if aerr, ok := err.(awserr.Error); ok {
+        return strInList(aerr.Code(), codes)
+    }
+    return false
+}
+
+
+// Check if given error is worth retrying
+func IsRetryableError(err error) bool {
+    if IsThrottleError(err) {
+        return true
+    }
+    if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= 500 {
+        return true
+    }
+    if aerr, ok := err.(awserr.Error); ok {
+        return strInList(aerr.Code(), transientErrorCodes)
+    }
+    return false
+}
+
+
+// Return AWS config for given region, with the SDK's own retries disabled in favour of ours
+func AWSConfig(region string) *aws.Config {
+    return aws.NewConfig().WithRegion(region).WithMaxRetries(0)
+}
+
+
+// Count one more retry for given service
+func AddRetryCount(service string) {
+    retryMutex.Lock()
+    defer retryMutex.Unlock()
+    RetryCounts[service]++
+}
+
+
+// Print retry counts per service, if there were any
+func PrintRetryCounts() {
+    if len(RetryCounts) == 0 {
+        return
+    }
+    var services []string
+    for service := range RetryCounts {
+        services = append(services, service)
+    }
+    sort.Strings(services)
+    var counts []string
+    for _, service := range services {
+        counts = append(counts, fmt.Sprintf("%s %d", service, RetryCounts[service]))
+    }
+    fmt.Printf("API retries: %s\n", strings.Join(counts, ", "))
+}
@@ -0,0 +1,449 @@
+// role.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "net/url"
+    "encoding/json"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/iam"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS iam.Role type to include these additional fields
+type RoleType struct {
+    AccountAlias      *string
+    AccountId         *string
+    Region            *string
+    AttachedPolicies  []*string   // Names of the managed policies attached to the role
+    *iam.Role
+}
+
+// Extend AWS iam.InstanceProfile type to include these additional fields
+type InstanceProfileType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *iam.InstanceProfile
+}
+
+// Register these resource kinds with the stores
+var (
+    RoleKind            = RegisterStoreKind("IAM role", RoleDataFile)
+    InstanceProfileKind = RegisterStoreKind("IAM instance profile", ProfileDataFile)
+)
+
+// Return string representation of this type
+func (s RoleType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *RoleType) SetAccountAlias(v string) *RoleType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *RoleType) SetAccountId(v string) *RoleType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *RoleType) SetRegion(v string) *RoleType {
+    s.Region = &v
+    return s
+}
+
+// Return string representation of this type
+func (s InstanceProfileType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *InstanceProfileType) SetAccountAlias(v string) *InstanceProfileType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *InstanceProfileType) SetAccountId(v string) *InstanceProfileType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *InstanceProfileType) SetRegion(v string) *InstanceProfileType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all IAM roles whose name matches given filter, each followed by the instances
+// running under it in any account
+func ListRolesAndInstances(filter string) {
+    roleList, err := GetRoleList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    profileList, _ := GetInstanceProfileList()
+    instList, _ := GetInstanceList()
+    roleInsts := GetInstancesByRole(instList, profileList)
+    for _, role := range roleList {
+        a, b, c, d, e := GetDetailsOfRole(role)
+        if filter != "" && !strContains(a, filter) {
+            continue
+        }
+        fmt.Printf("%-48s  %-18s  %-10s  %-50s  %s\n", a, b, c, d, e)
+        for _, inst := range roleInsts[aws.StringValue(role.Arn)] {
+            n, id, _, state, ip, acct, _, _, _, _, _, _, _, _ := GetInstanceDetails(inst)
+            fmt.Printf("    %-38s  %-20s  %-10s  %-16s  %s\n", n, id, state, ip, acct)
+        }
+    }
+}
+
+
+// Return given instances grouped by the ARN of the role of their instance profile, looking up
+// each profile's role only once
+func GetInstancesByRole(instList []InstanceType, profileList []InstanceProfileType) map[string][]*InstanceType {
+    profileRoles := map[string]string{}
+    for _, profile := range profileList {
+        if profile.InstanceProfile == nil || profile.Arn == nil {
+            continue
+        }
+        // A profile can only hold one role
+        for _, r := range profile.Roles {
+            if r != nil && r.Arn != nil {
+                profileRoles[*profile.Arn] = *r.Arn
+            }
+        }
+    }
+    roleInsts := map[string][]*InstanceType{}
+    for i := range instList {
+        inst := &instList[i]
+        if inst.Instance == nil || inst.IamInstanceProfile == nil || inst.IamInstanceProfile.Arn == nil {
+            continue
+        }
+        if roleArn, ok := profileRoles[*inst.IamInstanceProfile.Arn]; ok {
+            roleInsts[roleArn] = append(roleInsts[roleArn], inst)
+        }
+    }
+    return roleInsts
+}
+
+
+// Return IAM role records list from the data store
+func GetRoleList() (list []RoleType, err error) {
+    err = DataStore.Load(RoleKind, &list)
+    return list, err
+}
+
+
+// Return IAM instance profile records list from the data store
+func GetInstanceProfileList() (list []InstanceProfileType, err error) {
+    err = DataStore.Load(InstanceProfileKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfRole(role RoleType) (name, acctAlias, lastUsed, principals, policies string) {
+    name, acctAlias, lastUsed, principals, policies = "-", "-", "never", "-", "-"
+
+    if role.Role == nil {
+        return
+    }
+    if role.RoleName != nil { name = *role.RoleName }
+    if role.AccountAlias != nil { acctAlias = *role.AccountAlias }
+    if role.RoleLastUsed != nil && role.RoleLastUsed.LastUsedDate != nil {
+        lastUsed = role.RoleLastUsed.LastUsedDate.Format("2006-01-02")
+    }
+    if list := GetRoleTrustPrincipals(role); len(list) > 0 { principals = strings.Join(list, ",") }
+    if len(role.AttachedPolicies) > 0 {
+        policies = strings.Join(aws.StringValueSlice(role.AttachedPolicies), ",")
+    }
+    return
+}
+
+
+// Return the principals allowed to assume given role by its trust policy, e.g.
+// 'ec2.amazonaws.com' or 'arn:aws:iam::123456789012:root', sorted
+func GetRoleTrustPrincipals(role RoleType) (list []string) {
+    if role.AssumeRolePolicyDocument == nil {
+        return nil
+    }
+    // The policy document comes URL-encoded
+    doc, err := url.QueryUnescape(*role.AssumeRolePolicyDocument)
+    if err != nil {
+        return nil
+    }
+    var policy struct {
+        Statement []struct {
+            Effect    string
+            Principal interface{}
+        }
+    }
+    if err := json.Unmarshal([]byte(doc), &policy); err != nil {
+        return nil
+    }
+    add := func(v interface{}) {
+        if s, ok := v.(string); ok && !strInList(s, list) {
+            list = append(list, s)
+        }
+    }
+    for _, stmt := range policy.Statement {
+        if stmt.Effect != "Allow" {
+            continue
+        }
+        // Principals are either '*', or keyed by type with one or more values each
+        switch p := stmt.Principal.(type) {
+        case string:
+            add(p)
+        case map[string]interface{}:
+            for _, v := range p {
+                if values, ok := v.([]interface{}); ok {
+                    for _, value := range values {
+                        add(value)
+                    }
+                } else {
+                    add(v)
+                }
+            }
+        }
+    }
+    sort.Strings(list)
+    return list
+}
+
+
+// Return the role of given instance's profile, or nil if it has none or it isn't in given lists
+func GetRoleOfInstance(inst *InstanceType, profileList []InstanceProfileType,
+                       roleList []RoleType) *RoleType {
+    if inst.IamInstanceProfile == nil || inst.IamInstanceProfile.Arn == nil {
+        return nil
+    }
+    for _, profile := range profileList {
+        if profile.InstanceProfile == nil || aws.StringValue(profile.Arn) != *inst.IamInstanceProfile.Arn {
+            continue
+        }
+        // A profile can only hold one role
+        for _, r := range profile.Roles {
+            if r == nil || r.Arn == nil {
+                continue
+            }
+            for i := range roleList {
+                if roleList[i].Role != nil && aws.StringValue(roleList[i].Arn) == *r.Arn {
+                    return &roleList[i]
+                }
+            }
+        }
+    }
+    return nil
+}
+
+
+// Update local IAM role and instance profile stores from given AWS accounts
+func UpdateLocalRoleStoresFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts to update. IAM is global, so there's one sweep per account
+    sweeps := GetGlobalSweepList("iam", accounts, minutesAgo, GlobalRegion)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local IAM role and instance profile stores.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no IAM events within last minutesAgo
+            fmt.Printf("Skipping local IAM role and instance profile stores update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local IAM role and instance profile stores (%d accounts modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account concurrently
+    roleResults := make([][]RoleType, len(sweeps))
+    roleErrs := make([]error, len(sweeps))
+    profileResults := make([][]InstanceProfileType, len(sweeps))
+    profileErrs := make([]error, len(sweeps))
+    RunParallel("iam", len(sweeps), func(i int) {
+        roleResults[i], roleErrs[i] = GetRoleListFromAWS(sweeps[i].Account)
+        profileResults[i], profileErrs[i] = GetInstanceProfileListFromAWS(sweeps[i].Account)
+    })
+
+    // Keep previous role records for the accounts that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(RoleKind.Name, sweep.Account, sweep.Region, roleErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        // Create a new list from existing store, without the ones for the updated accounts
+        var list []RoleType
+        roleList, _ := GetRoleList()
+        for _, role := range roleList {
+            if !RecordInSweep(role.AccountId, role.Region, updated) {
+                list = append(list, role)
+            }
+        }
+        // Now add all the new records to this new list, and make it the new local list
+        for _, result := range roleResults {
+            list = append(list, result...)
+        }
+        WriteList(list, RoleKind)
+    }
+
+    // Same again for the instance profiles
+    updated = nil
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(InstanceProfileKind.Name, sweep.Account, sweep.Region, profileErrs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) > 0 {
+        var list []InstanceProfileType
+        profileList, _ := GetInstanceProfileList()
+        for _, profile := range profileList {
+            if !RecordInSweep(profile.AccountId, profile.Region, updated) {
+                list = append(list, profile)
+            }
+        }
+        for _, result := range profileResults {
+            list = append(list, result...)
+        }
+        WriteList(list, InstanceProfileKind)
+    }
+    return
+}
+
+
+// Return all IAM role objects in given account, with their last used date and the names of
+// their attached managed policies
+func GetRoleListFromAWS(acct *AccountType) (list []RoleType, err error) {
+    svc := iam.New(acct.Sess, AWSConfig(AWSRegion))
+
+    params := &iam.ListRolesInput{
+        MaxItems: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxItems records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *iam.ListRolesOutput
+        err := CallWithRetry("iam", func() (err error) {
+            resp, err = svc.ListRoles(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        for _, r := range resp.Roles {
+            if r == nil || r.RoleName == nil {
+                continue
+            }
+            // The list leaves out when roles were last used, so get each role in full
+            var resp2 *iam.GetRoleOutput
+            err := CallWithRetry("iam", func() (err error) {
+                resp2, err = svc.GetRole(&iam.GetRoleInput{RoleName: r.RoleName})
+                return err
+            })
+            if err != nil {
+                return nil, err
+            }
+            policies, err := GetAttachedRolePolicyNamesFromAWS(svc, r.RoleName)
+            if err != nil {
+                return nil, err
+            }
+            role := RoleType{AttachedPolicies: policies, Role: resp2.Role}
+            // Add our additional fields
+            role = *role.SetAccountAlias(acct.Alias)
+            role = *role.SetAccountId(acct.Id)
+            role = *role.SetRegion(GlobalRegion)
+            list = append(list, role)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.IsTruncated == nil || !*resp.IsTruncated {
+            break
+        } else {
+            params.Marker = resp.Marker
+        }
+    }
+    return list, nil
+}
+
+
+// Return names of the managed policies attached to given role
+func GetAttachedRolePolicyNamesFromAWS(svc *iam.IAM, roleName *string) (names []*string, err error) {
+    params := &iam.ListAttachedRolePoliciesInput{
+        RoleName: roleName,
+        MaxItems: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    for {
+        var resp *iam.ListAttachedRolePoliciesOutput
+        err := CallWithRetry("iam", func() (err error) {
+            resp, err = svc.ListAttachedRolePolicies(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+        for _, p := range resp.AttachedPolicies {
+            if p != nil && p.PolicyName != nil {
+                names = append(names, p.PolicyName)
+            }
+        }
+        if resp.IsTruncated == nil || !*resp.IsTruncated {
+            break
+        } else {
+            params.Marker = resp.Marker
+        }
+    }
+    return names, nil
+}
+
+
+// Return all IAM instance profile objects in given account
+func GetInstanceProfileListFromAWS(acct *AccountType) (list []InstanceProfileType, err error) {
+    svc := iam.New(acct.Sess, AWSConfig(AWSRegion))
+
+    params := &iam.ListInstanceProfilesInput{
+        MaxItems: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxItems records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *iam.ListInstanceProfilesOutput
+        err := CallWithRetry("iam", func() (err error) {
+            resp, err = svc.ListInstanceProfiles(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        for _, p := range resp.InstanceProfiles {
+            if p == nil || p.Arn == nil {
+                continue
+            }
+            profile := InstanceProfileType{InstanceProfile: p}
+            // Add our additional fields
+            profile = *profile.SetAccountAlias(acct.Alias)
+            profile = *profile.SetAccountId(acct.Id)
+            profile = *profile.SetRegion(GlobalRegion)
+            list = append(list, profile)
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.IsTruncated == nil || !*resp.IsTruncated {
+            break
+        } else {
+            params.Marker = resp.Marker
+        }
+    }
+    return list, nil
+}
@@ -0,0 +1,229 @@
+// search.go
+package main
+
+import (
+    "fmt"
+    "os"
+    "sort"
+    "bytes"
+    "strings"
+    "encoding/json"
+)
+
+// A record of any kind that matched a search
+type SearchMatchType struct {
+    Label   string      // Name and ID of the record, as far as it has them
+    Field   FieldType   // Best matching field
+    Rank    int         // 0 for an exact ID or name match, 1 for any other exact match, else 2
+    Fields  FieldList
+}
+
+// Fields that name a record, in order of preference, for labelling search matches
+var searchNameFields = []string{"tag:Name", "Name", "LoadBalancerName", "StackName", "FunctionName",
+    "AutoScalingGroupName", "DBInstanceIdentifier", "DBClusterIdentifier", "RoleName",
+    "InstanceProfileName", "GroupName", "ClusterName", "ServiceName", "DomainName"}
+
+// Fields that identify a record, in order of preference, for labelling search matches
+var searchIdFields = []string{"InstanceId", "Id", "StackId", "VolumeId", "SnapshotId", "VpcId",
+    "SubnetId", "GroupId", "AllocationId", "NetworkInterfaceId", "RouteTableId", "DNSName",
+    "CertificateArn", "LoadBalancerArn", "FunctionArn", "RoleId", "Arn"}
+
+// Terminal escapes to highlight the matched text with
+const (
+    highlightOn  = "\x1b[1;31m"
+    highlightOff = "\x1b[0m"
+)
+
+
+// Search all records of all kinds in the stores for given string, and display the matching ones
+// grouped by kind, with exact ID or name matches first
+func SearchStores(filter string) {
+    type kindMatches struct {
+        kind     *StoreKind
+        matches  []*SearchMatchType
+    }
+    var results []*kindMatches
+    for _, kind := range StoreKinds {
+        var raws []json.RawMessage
+        if err := DataStore.Load(kind, &raws); err != nil {
+            continue   // Fine if there's no store for this kind yet
+        }
+        var matches []*SearchMatchType
+        for _, raw := range raws {
+            fields, err := FlattenRecord(raw)
+            if err != nil {
+                continue
+            }
+            if match := SearchRecord(fields, filter); match != nil {
+                matches = append(matches, match)
+            }
+        }
+        if len(matches) > 0 {
+            sort.SliceStable(matches, func(i, j int) bool { return matches[i].Rank < matches[j].Rank })
+            results = append(results, &kindMatches{kind, matches})
+        }
+    }
+
+    // Kinds with the best matches go first, else they're in registration order
+    sort.SliceStable(results, func(i, j int) bool {
+        return results[i].matches[0].Rank < results[j].matches[0].Rank
+    })
+    highlight := IsTerminal(os.Stdout)
+    for _, r := range results {
+        fmt.Printf("%s  %d\n", r.kind.Name, len(r.matches))
+        for _, m := range r.matches {
+            acctAlias, _ := m.Fields.Get("AccountAlias")
+            region, _ := m.Fields.Get("Region")
+            if acctAlias == "" { acctAlias = "-" }
+            if region == "" { region = "-" }
+            fmt.Printf("  %-48s  %-18s  %-14s  %-28s  %s\n", m.Label, acctAlias, region, m.Field.Name,
+                HighlightMatch(m.Field.Value, filter, highlight))
+        }
+    }
+}
+
+
+// Return match of given record fields with given string, or nil if none of them contain it
+func SearchRecord(fields FieldList, filter string) *SearchMatchType {
+    var match *SearchMatchType
+    for _, f := range fields {
+        if !strContains(f.Value, filter) {
+            continue
+        }
+        rank := 2
+        // DNS names are kept with a trailing dot, which pasted ones don't have
+        if strings.EqualFold(strings.TrimSuffix(f.Value, "."), strings.TrimSuffix(filter, ".")) {
+            rank = 1
+            if IsSearchLabelField(f.Name) {
+                rank = 0
+            }
+        }
+        if match == nil || rank < match.Rank {
+            match = &SearchMatchType{Field: f, Rank: rank, Fields: fields}
+        }
+    }
+    if match != nil {
+        match.Label = GetSearchLabel(fields)
+    }
+    return match
+}
+
+
+// Check if given field names or identifies its record
+func IsSearchLabelField(name string) bool {
+    return strInList(name, searchNameFields) || strInList(name, searchIdFields)
+}
+
+
+// Return name and ID of a record with given fields, as far as it has them
+func GetSearchLabel(fields FieldList) string {
+    var parts []string
+    for _, names := range [][]string{searchNameFields, searchIdFields} {
+        for _, name := range names {
+            if value, ok := fields.Get(name); ok && value != "" {
+                if !strInList(value, parts) {
+                    parts = append(parts, value)
+                }
+                break
+            }
+        }
+    }
+    if len(parts) == 0 {
+        return "-"
+    }
+    return strings.Join(parts, " ")
+}
+
+
+// Return given value with the first occurrence of given string highlighted, if asked to, and
+// shortened to the part around it if the value is long
+func HighlightMatch(value, filter string, highlight bool) string {
+    i := strings.Index(strings.ToLower(value), strings.ToLower(filter))
+    if i < 0 || i + len(filter) > len(value) {
+        return value   // Not found, or case folding changed the lengths
+    }
+    before, match, after := value[:i], value[i:i+len(filter)], value[i+len(filter):]
+    if len(before) > 40 { before = "..." + before[len(before)-40:] }
+    if len(after) > 40 { after = after[:40] + "..." }
+    if highlight {
+        match = highlightOn + match + highlightOff
+    }
+    return before + match + after
+}
+
+
+// Check if given file is a terminal, as opposed to a pipe or a file
+func IsTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    return err == nil && info.Mode() & os.ModeCharDevice != 0
+}
+
+
+// Return all values of given JSON record as fields, named by their dotted path in the record,
+// in the order they're in. Tags, whether a list of Key/Value pairs or a map, become 'tag:KEY'
+// fields, and list elements share their list's path
+func FlattenRecord(raw json.RawMessage) (fields FieldList, err error) {
+    dec := json.NewDecoder(bytes.NewReader(raw))
+    dec.UseNumber()
+    err = flattenJSONValue(dec, "", &fields)
+    return fields, err
+}
+
+
+// Add the next JSON value in given decoder to given fields, under given path
+func flattenJSONValue(dec *json.Decoder, path string, fields *FieldList) error {
+    tok, err := dec.Token()
+    if err != nil {
+        return err
+    }
+    isTags := path == "Tags" || strings.HasSuffix(path, ".Tags")
+    switch t := tok.(type) {
+    case json.Delim:
+        if t == '[' {
+            for dec.More() {
+                if err := flattenJSONValue(dec, path, fields); err != nil {
+                    return err
+                }
+            }
+            _, err = dec.Token()   // Closing ']'
+            return err
+        }
+        // An object, whose members are added under its path
+        var members FieldList
+        for dec.More() {
+            keyTok, err := dec.Token()
+            if err != nil {
+                return err
+            }
+            key, _ := keyTok.(string)
+            childPath := key
+            if path != "" { childPath = path + "." + key }
+            if isTags {
+                childPath = "tag:" + key   // A map of tags, as for Lambda functions
+            }
+            if err := flattenJSONValue(dec, childPath, &members); err != nil {
+                return err
+            }
+        }
+        if _, err = dec.Token(); err != nil {   // Closing '}'
+            return err
+        }
+        // A Key/Value pair in a list of tags
+        if isTags && len(members) == 2 &&
+           members[0].Name == "tag:Key" && members[1].Name == "tag:Value" {
+            members = FieldList{{Name: "tag:" + members[0].Value, Value: members[1].Value}}
+        }
+        *fields = append(*fields, members...)
+    case string:
+        *fields = append(*fields, FieldType{Name: path, Value: t})
+    case json.Number:
+        *fields = append(*fields, FieldType{Name: path, Value: t.String()})
+    case bool:
+        *fields = append(*fields, FieldType{Name: path, Value: fmt.Sprint(t)})
+    case nil:
+        // Nothing to search in
+    default:
+        return fmt.Errorf("unexpected JSON token %v", tok)
+    }
+    return nil
+}
@@ -0,0 +1,397 @@
+// secgroup.go
+package main
+
+import (
+    "fmt"
+    "strings"
+    "strconv"
+    "encoding/json"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/service/ec2"
+    "github.com/aws/aws-sdk-go/aws/awsutil"
+)
+
+// Extend AWS ec2.SecurityGroup type to include these additional fields
+type SecurityGroupType struct {
+    AccountAlias  *string
+    AccountId     *string
+    Region        *string
+    *ec2.SecurityGroup
+}
+
+// Register this resource kind with the stores
+var SecGroupKind = RegisterStoreKind("Security group", SecGroupDataFile)
+
+// Return string representation of this type
+func (s SecurityGroupType) String() string {
+    return awsutil.Prettify(s)
+}
+
+// Set AccountAlias field's value
+func (s *SecurityGroupType) SetAccountAlias(v string) *SecurityGroupType {
+    s.AccountAlias = &v
+    return s
+}
+
+// Set AccountId field's value
+func (s *SecurityGroupType) SetAccountId(v string) *SecurityGroupType {
+    s.AccountId = &v
+    return s
+}
+
+// Set Region field's value
+func (s *SecurityGroupType) SetRegion(v string) *SecurityGroupType {
+    s.Region = &v
+    return s
+}
+
+
+// Display all security groups with applied filter, or all their rules if option is -gv
+func ListSecGroups(filter string, option string) {
+    sgList, err := GetSecGroupList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+    users := GetSecGroupUsers()   // So groups can also be found by the instances and ELBs using them
+    for _, sg := range sgList {
+        a, b, c, d, e := GetDetailsOfSecGroup(sg)
+        usedBy := strings.Join(users[a], " ")
+        if option == "-gv" {
+            // One line per rule, each of which can be filtered on
+            for _, rule := range GetSecGroupRules(sg) {
+                if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+                   strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+                   strContains(usedBy, filter) || strContains(rule, filter) {
+                    fmt.Printf("%-20s  %-32s  %-18s  %s\n", a, b, d, rule)
+                }
+            }
+            continue
+        }
+        if filter == "" || strContains(a, filter) || strContains(b, filter) ||
+           strContains(c, filter) || strContains(d, filter) || strContains(e, filter) ||
+           strContains(usedBy, filter) {
+            fmt.Printf("%-20s  %-32s  %-22s  %-18s  %-14s  %4d  %4d  %s\n",
+                a, b, c, d, e, len(sg.IpPermissions), len(sg.IpPermissionsEgress), usedBy)
+        }
+    }
+}
+
+
+// Return security group records list from the data store
+func GetSecGroupList() (list []SecurityGroupType, err error) {
+    err = DataStore.Load(SecGroupKind, &list)
+    return list, err
+}
+
+
+// Return important attributes of given object
+func GetDetailsOfSecGroup(sg SecurityGroupType) (groupId, groupName, vpcId, acctAlias, region string) {
+    groupId, groupName, vpcId, acctAlias, region = "-", "-", "-", "-", "-"
+
+    if sg.GroupId != nil { groupId = *sg.GroupId }
+    if sg.GroupName != nil { groupName = *sg.GroupName }
+    if sg.VpcId != nil { vpcId = *sg.VpcId }
+    if sg.AccountAlias != nil { acctAlias = *sg.AccountAlias }
+    if sg.Region != nil { region = *sg.Region }
+    return
+}
+
+
+// Return given group's rules, one string per rule and source/destination, e.g.,
+// 'in   tcp   443        0.0.0.0/0' or 'out  all   all        sg-0123abcd'
+func GetSecGroupRules(sg SecurityGroupType) (rules []string) {
+    for _, direction := range []string{"in", "out"} {
+        perms := sg.IpPermissions
+        if direction == "out" {
+            perms = sg.IpPermissionsEgress
+        }
+        for _, perm := range perms {
+            if perm == nil {
+                continue
+            }
+            protocol, ports := GetPermissionProtocolAndPorts(perm)
+            for _, peer := range GetPermissionPeers(perm) {
+                rules = append(rules, fmt.Sprintf("%-4s %-5s %-10s %s", direction, protocol, ports, peer))
+            }
+        }
+    }
+    return rules
+}
+
+
+// Return protocol and port range of given permission, as strings
+func GetPermissionProtocolAndPorts(perm *ec2.IpPermission) (protocol, ports string) {
+    protocol, ports = "-", "all"
+    if perm.IpProtocol != nil { protocol = *perm.IpProtocol }
+    if protocol == "-1" {
+        return "all", "all"
+    }
+    if perm.FromPort != nil && perm.ToPort != nil {
+        from, to := *perm.FromPort, *perm.ToPort
+        if from == to {
+            ports = strconv.FormatInt(from, 10)
+        } else if from != -1 && !(from == 0 && to == 65535) {
+            ports = strconv.FormatInt(from, 10) + "-" + strconv.FormatInt(to, 10)
+        }
+    }
+    return protocol, ports
+}
+
+
+// Return all CIDRs, prefix lists and groups given permission allows
+func GetPermissionPeers(perm *ec2.IpPermission) (peers []string) {
+    for _, r := range perm.IpRanges {
+        if r != nil && r.CidrIp != nil { peers = append(peers, *r.CidrIp) }
+    }
+    for _, r := range perm.Ipv6Ranges {
+        if r != nil && r.CidrIpv6 != nil { peers = append(peers, *r.CidrIpv6) }
+    }
+    for _, p := range perm.PrefixListIds {
+        if p != nil && p.PrefixListId != nil { peers = append(peers, *p.PrefixListId) }
+    }
+    for _, g := range perm.UserIdGroupPairs {
+        if g == nil || g.GroupId == nil {
+            continue
+        }
+        peers = append(peers, *g.GroupId)
+    }
+    return peers
+}
+
+
+// Return the ingress rule of given group that opens given port to the whole internet, if any,
+// as a string
+func GetSecGroupExposure(sg SecurityGroupType, port int64) (rule string, exposed bool) {
+    for _, perm := range sg.IpPermissions {
+        if perm == nil {
+            continue
+        }
+        // Only TCP and UDP have ports, while protocol -1 means all protocols and ports
+        protocol := aws.StringValue(perm.IpProtocol)
+        allPorts := protocol == "-1"
+        inRange := strInList(protocol, []string{"tcp", "udp", "6", "17"}) &&
+                   perm.FromPort != nil && perm.ToPort != nil &&
+                   *perm.FromPort <= port && port <= *perm.ToPort
+        if !allPorts && !inRange {
+            continue
+        }
+        protocol, ports := GetPermissionProtocolAndPorts(perm)
+        for _, r := range perm.IpRanges {
+            if r != nil && r.CidrIp != nil && *r.CidrIp == "0.0.0.0/0" {
+                return fmt.Sprintf("%s %s %s", protocol, ports, *r.CidrIp), true
+            }
+        }
+        for _, r := range perm.Ipv6Ranges {
+            if r != nil && r.CidrIpv6 != nil && *r.CidrIpv6 == "::/0" {
+                return fmt.Sprintf("%s %s %s", protocol, ports, *r.CidrIpv6), true
+            }
+        }
+    }
+    return "", false
+}
+
+
+// Display all instances and load balancers with a security group that allows given port from
+// anywhere, i.e., 0.0.0.0/0 or ::/0
+func ListExposedResources(portStr string) {
+    port, err := strconv.ParseInt(portStr, 10, 64)
+    if err != nil || port < 0 || port > 65535 {
+        Die(1, "Error. PORT (" + portStr + ") must be a number between 0 and 65535.")
+    }
+    sgList, err := GetSecGroupList()
+    if err != nil {
+        Die(1, err.Error())
+    }
+
+    // Work out which groups are exposed first
+    exposed := map[string]string{}
+    for _, sg := range sgList {
+        if sg.GroupId == nil {
+            continue
+        }
+        if rule, ok := GetSecGroupExposure(sg, port); ok {
+            exposed[*sg.GroupId] = rule
+        }
+    }
+    if len(exposed) == 0 {
+        return
+    }
+
+    printExposure := func(kind, name, id, acctAlias, region string, groupIds []string) {
+        for _, groupId := range groupIds {
+            if rule, ok := exposed[groupId]; ok {
+                fmt.Printf("%-8s  %-38s  %-40s  %-18s  %-14s  %-20s  %s\n",
+                    kind, name, id, acctAlias, region, groupId, rule)
+            }
+        }
+    }
+
+    instList, _ := GetInstanceList()
+    for _, inst := range instList {
+        var groupIds []string
+        for _, g := range inst.SecurityGroups {
+            if g != nil && g.GroupId != nil { groupIds = append(groupIds, *g.GroupId) }
+        }
+        a, b, _, _, _, f, _, _, _, _, _, _, _, _ := GetInstanceDetails(&inst)
+        region := "-"
+        if inst.Region != nil { region = *inst.Region }
+        printExposure("instance", a, b, f, region, groupIds)
+    }
+
+    elbList, _ := GetELBList()
+    for _, elb := range elbList {
+        name, dns, _, _ := GetDetailsOfELB(elb)
+        printExposure("elb", name, dns, aws.StringValue(elb.AccountAlias), aws.StringValue(elb.Region),
+                      aws.StringValueSlice(elb.SecurityGroups))
+    }
+
+    elbV2List, _ := GetELBV2List()
+    for _, elb := range elbV2List {
+        name, dns, _, _ := GetDetailsOfELBV2(elb)
+        printExposure("elbv2", name, dns, aws.StringValue(elb.AccountAlias), aws.StringValue(elb.Region),
+                      aws.StringValueSlice(elb.SecurityGroups))
+    }
+}
+
+
+// Return map of security group IDs to the instance IDs and load balancer names using them
+func GetSecGroupUsers() map[string][]string {
+    users := map[string][]string{}
+    instList, _ := GetInstanceList()
+    for _, inst := range instList {
+        if inst.InstanceId == nil {
+            continue
+        }
+        for _, g := range inst.SecurityGroups {
+            if g != nil && g.GroupId != nil {
+                users[*g.GroupId] = append(users[*g.GroupId], *inst.InstanceId)
+            }
+        }
+    }
+    elbList, _ := GetELBList()
+    for _, elb := range elbList {
+        name, _, _, _ := GetDetailsOfELB(elb)
+        for _, groupId := range aws.StringValueSlice(elb.SecurityGroups) {
+            users[groupId] = append(users[groupId], name)
+        }
+    }
+    elbV2List, _ := GetELBV2List()
+    for _, elb := range elbV2List {
+        name, _, _, _ := GetDetailsOfELBV2(elb)
+        for _, groupId := range aws.StringValueSlice(elb.SecurityGroups) {
+            users[groupId] = append(users[groupId], name)
+        }
+    }
+    return users
+}
+
+
+// Update local security group store from given AWS accounts
+func UpdateLocalSecGroupStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("ec2", accounts, minutesAgo)
+
+    // Do full update if minutesAgo is zero (meaning it wasn't specified)
+    if minutesAgo == 0 {
+        fmt.Printf("Updating local security group store.\n")
+    } else {
+        if len(sweeps) < 1 {
+            // Skip update if no EC2 events within last minutesAgo
+            fmt.Printf("Skipping local security group store update (no mods within %d minutes)\n",
+                minutesAgo)
+            return
+        }
+        fmt.Printf("Updating local security group store (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    results := make([][]SecurityGroupType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("ec2", len(sweeps), func(i int) {
+        results[i], errs[i] = GetSecGroupListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(SecGroupKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
+    }
+
+    // Create a new list from existing store, without the ones for the updated accounts and regions
+    var list []SecurityGroupType
+    sgList, _ := GetSecGroupList()
+    for _, sg := range sgList {
+        if !RecordInSweep(sg.AccountId, sg.Region, updated) {
+            list = append(list, sg)
+        }
+    }
+
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
+    }
+
+    // Make this the new local list
+    WriteList(list, SecGroupKind)
+    return
+}
+
+
+// Return all security group objects in given account and region
+func GetSecGroupListFromAWS(acct *AccountType, region string) (list []SecurityGroupType, err error) {
+    svc := ec2.New(acct.Sess, AWSConfig(region))
+
+    params := &ec2.DescribeSecurityGroupsInput{
+        MaxResults: aws.Int64(1000),  // 1000 is AWS max request limit
+    }
+    // Loop requests in case there're more than MaxResults records
+    for {
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *ec2.DescribeSecurityGroupsOutput
+        err := CallWithRetry("ec2", func() (err error) {
+            resp, err = svc.DescribeSecurityGroups(params)
+            return err
+        })
+        if err != nil {
+            return nil, err
+        }
+
+        // Ensure valid data came back
+        if resp.SecurityGroups != nil {
+            // Decode this batch into our extended type
+            // First convert it to raw []byte
+            jsonData, err := json.Marshal(resp.SecurityGroups)
+            if err != nil {
+                return nil, err
+            }
+            // Now read it into extended type list
+            var sgList []SecurityGroupType
+            err = json.Unmarshal(jsonData, &sgList)
+            if err != nil {
+                return nil, err
+            }
+            // Add this batch to our list
+            for _, sg := range sgList {
+                // Add our additional fields
+                sg = *sg.SetAccountAlias(acct.Alias)
+                sg = *sg.SetAccountId(acct.Id)
+                sg = *sg.SetRegion(region)
+                list = append(list, sg)
+            }
+        }
+
+        // Exit loop if no more records, else setup next batch request
+        if resp.NextToken == nil {
+            break
+        } else {
+            params.NextToken = resp.NextToken
+        }
+    }
+    return list, nil
+}
@@ -0,0 +1,177 @@
+// sort.go
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "strconv"
+)
+
+// Keys given with --sort, each a listing column, and descending if prefixed with '-'
+var OutputSort []string
+
+// Keys given with --group, each a listing column, for nested groups in that order
+var OutputGroups []string
+
+// Whether --count was given, to print only the row counts of the listing or of its groups
+var OutputCount = false
+
+
+// Set sort keys to given comma-separated list, e.g. 'account,-launched'
+func SetOutputSort(spec string) {
+    OutputSort = SplitOutputKeys(spec, "--sort")
+}
+
+
+// Set group keys to given comma-separated list, e.g. 'account,type'
+func SetOutputGroups(spec string) {
+    OutputGroups = SplitOutputKeys(spec, "--group")
+}
+
+
+// Return keys in given comma-separated list, aborting if there're none
+func SplitOutputKeys(spec, option string) (keys []string) {
+    for _, key := range strings.Split(spec, ",") {
+        if key = strings.TrimSpace(key); key != "" && key != "-" {
+            keys = append(keys, key)
+        }
+    }
+    if len(keys) == 0 {
+        Die(1, "Error. No keys in " + option + " '" + spec + "'")
+    }
+    return keys
+}
+
+
+// Sort rows of this listing by the group keys and then the sort keys, keeping their store
+// order otherwise, and set their group values
+func (l *ListingType) SortRows() {
+    if OutputGroups == nil && OutputSort == nil {
+        return
+    }
+    var cols []string
+    var descending []bool
+    for _, key := range OutputGroups {
+        cols = append(cols, key)
+        descending = append(descending, false)
+    }
+    for _, key := range OutputSort {
+        cols = append(cols, strings.TrimPrefix(key, "-"))
+        descending = append(descending, strings.HasPrefix(key, "-"))
+    }
+    values := l.GetColumnValues(cols)
+    for i, row := range l.Rows {
+        row.Groups = values[i][:len(OutputGroups)]
+    }
+
+    // Sort an index into the rows, so the values stay with them
+    index := make([]int, len(l.Rows))
+    for i := range index {
+        index[i] = i
+    }
+    sort.SliceStable(index, func(a, b int) bool {
+        for k := range cols {
+            x, y := values[index[a]][k], values[index[b]][k]
+            if c := CompareKeyValues(x, y); c != 0 {
+                if x == "-" || y == "-" {
+                    return c < 0   // Empty values go last either way
+                }
+                return (c < 0) != descending[k]
+            }
+        }
+        return false
+    })
+    rows := make([]*RowType, len(l.Rows))
+    for i, j := range index {
+        rows[i] = l.Rows[j]
+    }
+    l.Rows = rows
+}
+
+
+// Compare given key values, numerically if they're both numbers, and with empty '-' values
+// after all others
+func CompareKeyValues(a, b string) int {
+    if a == b {
+        return 0
+    }
+    if a == "-" { return 1 }
+    if b == "-" { return -1 }
+    if x, err := strconv.ParseFloat(a, 64); err == nil {
+        if y, err := strconv.ParseFloat(b, 64); err == nil {
+            if x < y { return -1 }
+            if x > y { return 1 }
+            return 0
+        }
+    }
+    return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+
+// Return number of rows of this listing in each group and parent group, keyed by their values
+func (l *ListingType) GetGroupCounts() map[string]int {
+    counts := map[string]int{}
+    for _, row := range l.Rows {
+        for i := range row.Groups {
+            counts[strings.Join(row.Groups[:i+1], "\x00")]++
+        }
+    }
+    return counts
+}
+
+
+// Print a header line with the row count of each group in given one that isn't in the previous
+// one, indenting nested groups
+func PrintGroupHeaders(prev, groups []string, counts map[string]int) {
+    start := 0
+    for start < len(groups) && start < len(prev) && groups[start] == prev[start] {
+        start++
+    }
+    for i := start ; i < len(groups) ; i++ {
+        fmt.Printf("%s%s=%s  %d\n", strings.Repeat("  ", i), OutputGroups[i], groups[i],
+            counts[strings.Join(groups[:i+1], "\x00")])
+    }
+}
+
+
+// Print only the row counts of this listing, of each of its groups if any. Other output formats
+// get one record per innermost group, with its keys and count
+func (l *ListingType) PrintCounts() {
+    counts := l.GetGroupCounts()
+    if OutputFormat != "" {
+        fields := append(append([]string{}, OutputGroups...), "count")
+        summary := NewListing(fields)
+        var prev []string
+        for _, row := range l.Rows {
+            if prev != nil && strings.Join(prev, "\x00") == strings.Join(row.Groups, "\x00") {
+                continue
+            }
+            prev = row.Groups
+            count := len(l.Rows)
+            if len(row.Groups) > 0 {
+                count = counts[strings.Join(row.Groups, "\x00")]
+            }
+            summary.Add(NewFieldList(fields, append(append([]string{}, row.Groups...),
+                strconv.Itoa(count))...), nil, nil)
+            if len(row.Groups) == 0 {
+                break   // Just the total
+            }
+        }
+        if len(l.Rows) == 0 && OutputGroups == nil {
+            summary.Add(NewFieldList(fields, "0"), nil, nil)
+        }
+        summary.Encode()
+        return
+    }
+    var prev []string
+    for _, row := range l.Rows {
+        PrintGroupHeaders(prev, row.Groups, counts)
+        prev = row.Groups
+    }
+    if OutputGroups != nil {
+        fmt.Printf("total  %d\n", len(l.Rows))
+    } else {
+        fmt.Println(len(l.Rows))
+    }
+}
@@ -3,11 +3,8 @@ package main
 
 import (
     "fmt"
-    "time"
     "strings"
     "encoding/json"
-    "github.com/aws/aws-sdk-go/aws"
-    "github.com/aws/aws-sdk-go/aws/session"
     "github.com/aws/aws-sdk-go/service/cloudformation"
     "github.com/aws/aws-sdk-go/aws/awsutil"
 )
@@ -17,9 +14,13 @@ import (
 type StackType struct {
     AccountAlias  *string
     AccountId     *string
+    Region        *string
     *cloudformation.Stack
 }
 
+// Register this resource kind with the stores
+var StackKind = RegisterStoreKind("CloudFormation stack", StackDataFile)
+
 // Return string representation of this type
 func (s StackType) String() string {
     return awsutil.Prettify(s)
@@ -37,15 +38,27 @@ func (s *StackType) SetAccountId(v string) *StackType {
     return s
 }
 
+// Set Region field's value
+func (s *StackType) SetRegion(v string) *StackType {
+    s.Region = &v
+    return s
+}
+
+
+// Query fields of stack records
+var StackFields = []string{"name", "account", "status", "updated", "id", "region"}
 
 // Display all stack records with applied filter
 func ListStacks(filter, option string) {
+    query := ParseQueryOrDie(filter, StackFields)
     stkList, err := GetStackList()
     if err != nil {
         Die(1, err.Error())
     }
+    listing := NewListing(StackFields)
     for _, stkRec := range stkList {
-        stkName, acctAlias, stkStatus, stkId, lastUpdate := "-", "-", "-", "-", "-"
+        stkRec := stkRec   // For the closure below
+        stkName, acctAlias, stkStatus, stkId, lastUpdate, region := "-", "-", "-", "-", "-", "-"
         if stkRec.StackStatus == nil {
             panic("Error. This stack record is missing field StackStatus.")
         } else {
@@ -57,15 +70,16 @@ func ListStacks(filter, option string) {
         if stkRec.StackName != nil { stkName = *stkRec.StackName }
         if stkRec.AccountAlias != nil { acctAlias = *stkRec.AccountAlias }
         if stkRec.StackId != nil { stkId = *stkRec.StackId }
+        if stkRec.Region != nil { region = *stkRec.Region }
 	    if stkRec.LastUpdatedTime != nil {
 	        lu := *stkRec.LastUpdatedTime
 	        lastUpdate = lu.Format("2006-01-02 15:04")
 	    }	
-        if filter == "" || strContains(stkName, filter) ||
-                           strContains(stkId, filter) ||
-                           strContains(acctAlias, filter) ||
-                           strContains(stkStatus, filter) {
-
+        fields := NewFieldList(StackFields, stkName, acctAlias, stkStatus, lastUpdate, stkId, region)
+        if !query.Match(fields) {
+            continue
+        }
+        listing.Add(fields, stkRec, func() {
             //  Replace spaces with period and shorten names
             if len(stkName) > 50 { stkName = stkName[:50] }
             stkName = strings.Replace(stkName, " ", ".", -1)
@@ -80,99 +94,93 @@ func ListStacks(filter, option string) {
     	        	}
     	        }
             }
-        }
+        })
     }
+    listing.Flush()
     return
 }
 
 
-// Return stack records list in local or remote store
+// Return stack records list from the data store
 func GetStackList() (list []StackType, err error) {
-    localFileTimestamp := GetLocalFileTime(StackDataFile)
-    remoteFileTimestamp := GetRemoteFileTime(StackDataFile)
-
-    // Use remote S3 file if it's newer
-    if remoteFileTimestamp.After(localFileTimestamp) {
-        tmplist, err := GetListFromRemote(StackDataFile)
-        list = tmplist.([]StackType)         // Assert our stack type
-        if err == nil {
-            WriteList(list, StackDataFile)   // Update local with this newer set
-            return list, nil
-        }        
-        // Return what must be an empty list with the error code
-        return list, err
-    }
-
-    // Else, just return local file content with error code
-    tmplist, err := GetListFromLocal(StackDataFile)
-    list = tmplist.([]StackType)
+    err = DataStore.Load(StackKind, &list)
     return list, err
 }
 
 
-// Update local stack store from current AWS account
-func UpdateLocalStackStoreFromAWS(minutesAgo int) {
+// Update local stack store from given AWS accounts
+func UpdateLocalStackStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts and regions to update
+    sweeps := GetSweepList("cloudformation", accounts, minutesAgo)
+
     // Do full update if minutesAgo is zero (meaning it wasn't specified)
     if minutesAgo == 0 {
         fmt.Printf("Updating local CloudFormation stack store\n")
     } else {
-        updatedStackCount := len(GetCloudTrailEvents("cloudformation", minutesAgo)) 
-        if updatedStackCount < 1 {
+        if len(sweeps) < 1 {
             // Skip stack update if no cloudformation events within last minutesAgo
             fmt.Printf("Skipping local CloudFormation stack store update (no mods within %d minutes)\n",
                 minutesAgo)
             return
         }
-        fmt.Printf("Updating local CloudFormation stack store (%d modified within %d minutes)\n",
-            updatedStackCount, minutesAgo)
+        fmt.Printf("Updating local CloudFormation stack store (%d regions modified within %d minutes)\n",
+            len(sweeps), minutesAgo)
+    }
+
+    // Get all records for each account and region concurrently
+    results := make([][]StackType, len(sweeps))
+    errs := make([]error, len(sweeps))
+    RunParallel("cloudformation", len(sweeps), func(i int) {
+        results[i], errs[i] = GetStackListFromAWS(sweeps[i].Account, sweeps[i].Region)
+    })
+
+    // Keep previous records for the accounts and regions that failed
+    var updated []SweepType
+    for i, sweep := range sweeps {
+        if UpdateReport.Add(StackKind.Name, sweep.Account, sweep.Region, errs[i]) {
+            updated = append(updated, sweep)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
     }
 
-    // Create a new list from existing store, without the ones for the current AWS account
+    // Create a new list from existing store, without the ones for the updated accounts and regions
     var list []StackType
     stackList, _ := GetStackList()
     for _, stack := range stackList {
-        if *stack.AccountId != AWSAccountId {
+        if !RecordInSweep(stack.AccountId, stack.Region, updated) {
             list = append(list, stack)
         }
     }
 
-    // Now get all records for this account, and add them to this new list
-    for _, stack := range GetStackListFromAWS() {
-        list = append(list, stack)
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
     }
 
     // Make this the new local list
-    WriteList(list, StackDataFile)
+    WriteList(list, StackKind)
     return
 }
 
 
-// Return all stack objects in current AWS account
-func GetStackListFromAWS() (list []StackType) {
-    SetAWSRegion()
-    sess := session.Must(session.NewSession())
-    svc := cloudformation.New(sess, aws.NewConfig().WithRegion(AWSRegion))
+// Return all stack objects in given account and region
+func GetStackListFromAWS(acct *AccountType, region string) (list []StackType, err error) {
+    svc := cloudformation.New(acct.Sess, AWSConfig(region))
 
     params := &cloudformation.DescribeStacksInput{}
 
-    // Loop requests in case there're more than PageSize records or we're being throttled
-    errcount := 0
+    // Loop requests in case there're more than PageSize records
     for {
-        // Get batch of records
-        resp, err := svc.DescribeStacks(params)
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *cloudformation.DescribeStacksOutput
+        err := CallWithRetry("cloudformation", func() (err error) {
+            resp, err = svc.DescribeStacks(params)
+            return err
+        })
         if err != nil {
-            // Sleep for a moment if AWS is throttling us
-            if BeingThrottled(err) {
-                fmt.Printf("  AWS throttling. Sleeping %d seconds...\n", APISecondsDelay)
-                time.Sleep(time.Duration(APISecondsDelay) * time.Second)
-                continue
-            }
-            // Allow for 3 other unknown API call errors before panicking
-            if errcount < 3 {
-                errcount++
-                continue
-            }
-            panic(err.Error())   // Abort on any other error
+            return nil, err
         }
 
         // Ensure valid data came back
@@ -181,19 +189,20 @@ func GetStackListFromAWS() (list []StackType) {
             // First convert it to raw []byte
             jsonData, err := json.Marshal(resp.Stacks)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Now read it into extended type list
             var stkList []StackType
             err = json.Unmarshal(jsonData, &stkList)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Add this batch to our list
             for _, stk := range stkList {
                 // Add our additional fields
-                stk = *stk.SetAccountAlias(AWSAccountAlias)
-                stk = *stk.SetAccountId(AWSAccountId)
+                stk = *stk.SetAccountAlias(acct.Alias)
+                stk = *stk.SetAccountId(acct.Id)
+                stk = *stk.SetRegion(region)
                 list = append(list, stk)
             }            
         }
@@ -205,5 +214,5 @@ func GetStackListFromAWS() (list []StackType) {
             params.NextToken = resp.NextToken
         }
     }
-    return list
+    return list, nil
 }
@@ -0,0 +1,192 @@
+// store.go
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+    "bytes"
+    "errors"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "github.com/aws/aws-sdk-go/aws"
+    "github.com/aws/aws-sdk-go/aws/session"
+    "github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+
+// A kind of resource kept in the stores, e.g., EC2 instances or DNS records
+type StoreKind struct {
+    Name      string   // Short description, used in messages
+    DataFile  string   // Name of the JSON file holding all records of this kind
+}
+
+// All registered resource kinds, in registration order
+var StoreKinds []*StoreKind
+
+// Register a new resource kind, so it's handled along with all the others
+func RegisterStoreKind(name, dataFile string) *StoreKind {
+    kind := &StoreKind{Name: name, DataFile: dataFile}
+    StoreKinds = append(StoreKinds, kind)
+    return kind
+}
+
+
+// A store backend. Lists are always passed as pointers to a typed slice, e.g., *[]InstanceType
+type Store interface {
+    Load(kind *StoreKind, list interface{}) error   // Read all records of given kind into list
+    Save(kind *StoreKind, list interface{}) error   // Replace all records of given kind with list
+    Stat(kind *StoreKind) time.Time                 // Time of last update in UTC, zero if none
+}
+
+// The store used by all getters and updaters. This gets set in SetupDataStore()
+var DataStore Store
+
+
+// Set up the global data store, which is always a hybrid of the local store directory and the
+// configured S3 bucket
+func SetupDataStore() {
+    // Hybrid mode: keep and use local copies, but grab remote ones whenever they're newer
+    DataStore = &HybridStore{
+        Local:  &LocalStore{Dir: progConfDir},
+        Remote: &S3Store{Bucket: S3Bucket, URLBase: S3URLBase},
+    }
+}
+
+
+// Store backed by JSON files in a local directory
+type LocalStore struct {
+    Dir  string
+}
+
+// Read list from local file
+func (s *LocalStore) Load(kind *StoreKind, list interface{}) error {
+    localFile := filepath.Join(s.Dir, kind.DataFile)
+    jsonData, err := ioutil.ReadFile(localFile)
+    if err != nil {
+        return errors.New(fmt.Sprintf("Can't read file %s", localFile))
+    }
+    if err = json.Unmarshal(jsonData, list); err != nil {
+        return errors.New(fmt.Sprintf("Can't unmarshal %s", kind.DataFile))
+    }
+    return nil
+}
+
+// Write list to local file
+func (s *LocalStore) Save(kind *StoreKind, list interface{}) error {
+    localFile := filepath.Join(s.Dir, kind.DataFile)
+    jsonData, err := json.Marshal(list)
+    if err != nil {
+        return err
+    }
+    return ioutil.WriteFile(localFile, jsonData, 0600)
+}
+
+// Return local file time in UTC
+func (s *LocalStore) Stat(kind *StoreKind) (t time.Time) {
+    fileinfo, err := os.Stat(filepath.Join(s.Dir, kind.DataFile))
+    if err != nil {
+        return t  // Return zero time
+    }
+    return fileinfo.ModTime().UTC()
+}
+
+
+// Store backed by an S3 bucket, read over plain HTTP and written with the AWS SDK
+type S3Store struct {
+    Bucket   string
+    URLBase  string
+}
+
+// Read list from remote file
+func (s *S3Store) Load(kind *StoreKind, list interface{}) error {
+    S3FileUrl := s.URLBase + "/" + kind.DataFile
+    resp, err := http.Get(S3FileUrl)
+    if err != nil {
+        return errors.New(fmt.Sprintf("Can't http.Get %s", S3FileUrl))
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != 200 {
+        return errors.New(fmt.Sprintf("URL %s returns a non-200 error", S3FileUrl))
+    }
+    jsonData, err := ioutil.ReadAll(resp.Body)
+    if err != nil {
+        return errors.New(fmt.Sprintf("Can't read body of %s", S3FileUrl))
+    }
+    if err = json.Unmarshal(jsonData, list); err != nil {
+        return errors.New(fmt.Sprintf("Can't unmarshal %s", kind.DataFile))
+    }
+    return nil
+}
+
+// Upload list to S3 bucket, which requires AWS access
+func (s *S3Store) Save(kind *StoreKind, list interface{}) error {
+    jsonData, err := json.Marshal(list)
+    if err != nil {
+        return err
+    }
+    SetAWSRegion()
+    sess := session.Must(session.NewSession())
+    uploader := s3manager.NewUploader(sess)
+    params := &s3manager.UploadInput{
+        Bucket: aws.String(s.Bucket),
+        Key:    aws.String(kind.DataFile),
+        Body:   bytes.NewReader(jsonData),
+    }
+    _, err = uploader.Upload(params)
+    return err
+}
+
+// Return remote file time in UTC
+func (s *S3Store) Stat(kind *StoreKind) (t time.Time) {
+    S3FileUrl := s.URLBase + "/" + kind.DataFile
+    resp, err := http.Head(S3FileUrl)
+    if err != nil {
+        return t
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == 200 {
+        lastModifiedDate := resp.Header.Get("Last-Modified")
+        lmt, err := time.Parse(time.RFC1123, lastModifiedDate)
+        if err == nil {
+            return lmt.UTC()
+        }
+    }
+    // If anything, just return zero time
+    return t
+}
+
+
+// Store wrapping a local and a remote store: reads come from the remote one only when it's
+// newer, in which case the local copy is refreshed; writes only ever go to the local one
+type HybridStore struct {
+    Local   Store
+    Remote  Store
+}
+
+// Read list from the newest of the two stores
+func (s *HybridStore) Load(kind *StoreKind, list interface{}) error {
+    if s.Remote.Stat(kind).After(s.Local.Stat(kind)) {
+        if err := s.Remote.Load(kind, list); err != nil {
+            return err
+        }
+        return s.Local.Save(kind, list)   // Update local with this newer set
+    }
+    return s.Local.Load(kind, list)
+}
+
+// Write list to local store
+func (s *HybridStore) Save(kind *StoreKind, list interface{}) error {
+    return s.Local.Save(kind, list)
+}
+
+// Return the newest time of the two stores
+func (s *HybridStore) Stat(kind *StoreKind) time.Time {
+    localTime, remoteTime := s.Local.Stat(kind), s.Remote.Stat(kind)
+    if remoteTime.After(localTime) {
+        return remoteTime
+    }
+    return localTime
+}
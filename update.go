@@ -0,0 +1,184 @@
+// update.go
+package main
+
+import (
+    "fmt"
+    "sync"
+)
+
+
+// Records of global services are stamped with this instead of a region
+const GlobalRegion = "global"
+
+// Region that global services like Route53, IAM and CloudFront are served from, and where their
+// CloudTrail events land
+const GlobalEventsRegion = "us-east-1"
+
+// An account and region pair to update
+type SweepType struct {
+    Account  *AccountType
+    Region   string
+}
+
+
+// Update all local stores from given AWS accounts, running the collectors concurrently
+func UpdateLocalStoresFromAWS(accounts []*AccountType, targetZones []string, minutesAgo int) {
+    var wg sync.WaitGroup
+    run := func(update func()) {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            update()
+        }()
+    }
+    run(func() { UpdateLocalInstanceStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalSecGroupStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalNetworkStoresFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalEBSStoresFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalENIStoresFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalELBStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalELBV2StoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalCertStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalRoleStoresFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalASGStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalRDSStoresFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalLambdaStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalECSStoresFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalCloudFrontStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalBucketStoreFromAWS(accounts, minutesAgo) })
+    run(func() { UpdateLocalStackStoreFromAWS(accounts, minutesAgo) })
+    run(func() {
+        // DNS updates go thru the zone store, so they have to wait for it
+        UpdateLocalZoneStoreFromAWS(accounts, minutesAgo)
+        UpdateLocalDNSStoreFromAWS(accounts, targetZones, minutesAgo)
+    })
+    run(func() { UpdateLocalHealthCheckStoreFromAWS(accounts, minutesAgo) })
+    wg.Wait()
+}
+
+
+// Return list of account and region pairs to update for given CloudTrail source; all of them
+// if minutesAgo is zero, else only those with events within last minutesAgo
+func GetSweepList(source string, accounts []*AccountType, minutesAgo int) (list []SweepType) {
+    var all []SweepType
+    for _, acct := range accounts {
+        for _, region := range acct.Regions {
+            all = append(all, SweepType{Account: acct, Region: region})
+        }
+    }
+    if minutesAgo == 0 {
+        return all
+    }
+
+    // Check CloudTrail in each account and region concurrently
+    counts := make([]int, len(all))
+    RunParallel("cloudtrail", len(all), func(i int) {
+        events, err := GetCloudTrailEvents(all[i].Account, source, all[i].Region, minutesAgo)
+        if err != nil {
+            // Can't tell whether anything changed, so update it anyway
+            fmt.Printf("  Unable to check CloudTrail for %s %s, updating anyway: %s\n",
+                all[i].Account.Alias, all[i].Region, err.Error())
+            counts[i] = 1
+            return
+        }
+        counts[i] = len(events)
+    })
+    for i, sweep := range all {
+        if counts[i] > 0 {
+            list = append(list, sweep)
+        }
+    }
+    return list
+}
+
+
+// Return one sweep per given account for a global service, stamped with given pseudo-region; all
+// of them if minutesAgo is zero, else only those with events within last minutesAgo. Global
+// services log their CloudTrail events in us-east-1
+func GetGlobalSweepList(source string, accounts []*AccountType, minutesAgo int, region string) (list []SweepType) {
+    if minutesAgo == 0 {
+        for _, acct := range accounts {
+            list = append(list, SweepType{Account: acct, Region: region})
+        }
+        return list
+    }
+
+    counts := make([]int, len(accounts))
+    RunParallel("cloudtrail", len(accounts), func(i int) {
+        events, err := GetCloudTrailEvents(accounts[i], source, GlobalEventsRegion, minutesAgo)
+        if err != nil {
+            fmt.Printf("  Unable to check CloudTrail for %s %s, updating anyway: %s\n",
+                accounts[i].Alias, region, err.Error())
+            counts[i] = 1
+            return
+        }
+        counts[i] = len(events)
+    })
+    for i, acct := range accounts {
+        if counts[i] > 0 {
+            list = append(list, SweepType{Account: acct, Region: region})
+        }
+    }
+    return list
+}
+
+
+// Return given sweep lists merged into one, without duplicates
+func MergeSweepLists(a, b []SweepType) (list []SweepType) {
+    list = append(list, a...)
+    for _, sweep := range b {
+        found := false
+        for _, s := range a {
+            if s.Account == sweep.Account && s.Region == sweep.Region {
+                found = true
+                break
+            }
+        }
+        if !found {
+            list = append(list, sweep)
+        }
+    }
+    return list
+}
+
+
+// Check if record of given account and region is about to be replaced by given sweeps. Records
+// from before regions were stamped match any region
+func RecordInSweep(accountId, region *string, sweeps []SweepType) bool {
+    if accountId == nil {
+        return false
+    }
+    for _, sweep := range sweeps {
+        if *accountId == sweep.Account.Id && (region == nil || *region == sweep.Region) {
+            return true
+        }
+    }
+    return false
+}
+
+
+// Return list of given accounts with changes to Route53 zones within last minutesAgo, or all of
+// them if minutesAgo is zero
+func GetUpdatedZoneAccountList(accounts []*AccountType, minutesAgo int) (list []*AccountType) {
+    if minutesAgo == 0 {
+        return accounts
+    }
+    counts := make([]int, len(accounts))
+    RunParallel("cloudtrail", len(accounts), func(i int) {
+        zoneIdList, err := GetUpdatedZoneIdList(accounts[i], minutesAgo)
+        if err != nil {
+            // Can't tell whether anything changed, so update it anyway
+            fmt.Printf("  Unable to check CloudTrail for %s, updating anyway: %s\n",
+                accounts[i].Alias, err.Error())
+            counts[i] = 1
+            return
+        }
+        counts[i] = len(zoneIdList)
+    })
+    for i, acct := range accounts {
+        if counts[i] > 0 {
+            list = append(list, acct)
+        }
+    }
+    return list
+}
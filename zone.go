@@ -3,11 +3,11 @@ package main
 
 import (
     "fmt"
-    "time"
     "strings"
+    "strconv"
+    "errors"
     "encoding/json"
     "github.com/aws/aws-sdk-go/aws"
-    "github.com/aws/aws-sdk-go/aws/session"
     "github.com/aws/aws-sdk-go/service/route53"
     "github.com/aws/aws-sdk-go/aws/awsutil"
 )
@@ -19,6 +19,9 @@ type HostedZoneType struct {
     *route53.HostedZone
 }
 
+// Register this resource kind with the stores
+var ZoneKind = RegisterStoreKind("DNS zone", ZoneDataFile)
+
 // Return string representation of this type
 func (s HostedZoneType) String() string {
     return awsutil.Prettify(s)
@@ -37,13 +40,19 @@ func (s *HostedZoneType) SetAccountId(v string) *HostedZoneType {
 }
 
 
+// Query fields of zone records
+var ZoneFields = []string{"name", "type", "records", "id", "account"}
+
 // Display all zones records with applied filter
 func ListZones(filter string) {
+    query := ParseQueryOrDie(filter, ZoneFields)
     list, err := GetZoneList()
     if err != nil {
         Die(1, err.Error())
     }
+    listing := NewListing(ZoneFields)
     for _, zone := range list {
+        zone := zone   // For the closure below
         zoneName, zoneType := "-", "public"
         if zone.Name != nil {
             zoneName = strings.TrimSuffix(*zone.Name, ".")  // Remove useless dotted suffix
@@ -58,74 +67,89 @@ func ListZones(filter string) {
         if zone.AccountAlias != nil { accAlias = *zone.AccountAlias }
 
         // Print all qualifying entries
-        if filter == "" || strContains(zoneName, filter) ||
-                           strContains(zoneType, filter) ||
-                           strContains(accAlias, filter) ||
-                           strContains(*zone.Id, filter) {
-            fmt.Printf("%-44s  %-8s  %6d  %-30s  %-18s\n", zoneName, zoneType,
-                *zone.ResourceRecordSetCount, *zone.Id, accAlias)
+        fields := NewFieldList(ZoneFields, zoneName, zoneType,
+            strconv.FormatInt(aws.Int64Value(zone.ResourceRecordSetCount), 10), *zone.Id, accAlias)
+        if query.Match(fields) {
+            listing.Add(fields, zone, func() {
+                fmt.Printf("%-44s  %-8s  %6d  %-30s  %-18s\n", zoneName, zoneType,
+                    *zone.ResourceRecordSetCount, *zone.Id, accAlias)
+            })
         }
     }
+    listing.Flush()
     return
 }
 
 
-// Return zone records list in local or remote store
+// Return zone records list from the data store
 func GetZoneList() (list []HostedZoneType, err error) {
-    localFileTimestamp := GetLocalFileTime(ZoneDataFile)
-    remoteFileTimestamp := GetRemoteFileTime(ZoneDataFile)
-
-    // Use remote S3 file if it's newer
-    if remoteFileTimestamp.After(localFileTimestamp) {
-        tmplist, err := GetListFromRemote(ZoneDataFile)
-        list = tmplist.([]HostedZoneType)    // Assert our zone type
-        if err == nil {
-            WriteList(list, ZoneDataFile)   // Update local with this newer set
-            return list, nil
-        }
-        // Return what must be an empty list with the error code
-        return list, err
-    }
-
-    // Else, just return local file content with error code
-    tmplist, err := GetListFromLocal(ZoneDataFile)
-    list = tmplist.([]HostedZoneType)
+    err = DataStore.Load(ZoneKind, &list)
     return list, err
 }
 
 
-// Return list of zoneIDs for zones that have changed within minutesAgo or in the last 7 days
-func GetUpdatedZoneIdList(minutesAgo int) (list []string) {
+// Return list of zoneIDs for zones in given account that have changed within minutesAgo or in
+// the last 7 days
+func GetUpdatedZoneIdList(acct *AccountType, minutesAgo int) (list []string, err error) {
     // Get all route53 Cloudtrail events within minutesAgo
-    for _, eventString := range GetCloudTrailEvents("route53", minutesAgo) {
+    // Route53 is a global service, whose events are all in the same region
+    eventList, err := GetCloudTrailEvents(acct, "route53", GlobalEventsRegion, minutesAgo)
+    if err != nil {
+        return nil, err
+    }
+    for _, eventString := range eventList {
         // The embedded CloudTrailEvent field is a doubly encoded json string whose structure we
         // don't know. Let's convert it to a mapped string, so we can parse it below. This would
         // be easier if the API had a CloudTrailEvent struct type.
         var obj map[string]interface{}
+        if eventString.CloudTrailEvent == nil {
+            continue
+        }
         err := json.Unmarshal([]byte(*eventString.CloudTrailEvent), &obj)
         if err != nil {
-            panic(err.Error())
+            return nil, err
         }
 
         // Parse the mapped string event object, looking for where the zoneId is
+        unexpected := errors.New("Unexpected CloudTrail event format: " + *eventString.CloudTrailEvent)
         for k, v := range obj {
             var zoneId string
             // See if the updated zoneId is under requestParameters
             if strings.EqualFold(k, "requestParameters") && v != nil {
-                for k2, v2 := range v.(map[string]interface{}) {
+                params, ok := v.(map[string]interface{})
+                if !ok {
+                    return nil, unexpected
+                }
+                for k2, v2 := range params {
                     if strings.EqualFold(k2, "hostedZoneId") {
-                        zoneId = "/hostedzone/" + v2.(string)
+                        id, ok := v2.(string)
+                        if !ok {
+                            return nil, unexpected
+                        }
+                        zoneId = "/hostedzone/" + id
                         list = AppendIfMissing(list, zoneId)
                     }
                 }
             }
             // Else, see if it's under responseElements
             if zoneId == "" && strings.EqualFold(k, "responseElements") && v != nil {
-                for k2, v2 := range v.(map[string]interface{}) {
+                elements, ok := v.(map[string]interface{})
+                if !ok {
+                    return nil, unexpected
+                }
+                for k2, v2 := range elements {
                     if strings.EqualFold(k2, "hostedZone") && v2 != nil {
-                        for k3, v3 := range v2.(map[string]interface{}) {
+                        zone, ok := v2.(map[string]interface{})
+                        if !ok {
+                            return nil, unexpected
+                        }
+                        for k3, v3 := range zone {
                             if strings.EqualFold(k3, "id") {
-                                zoneId = v3.(string)
+                                id, ok := v3.(string)
+                                if !ok {
+                                    return nil, unexpected
+                                }
+                                zoneId = id
                                 list = AppendIfMissing(list, zoneId)
                             }
                         }
@@ -134,7 +158,7 @@ func GetUpdatedZoneIdList(minutesAgo int) (list []string) {
             }
         }
     }
-    return list
+    return list, nil
 }
 
 
@@ -161,71 +185,81 @@ func IdInZoneList(zoneId string, list []HostedZoneType) bool {
 }
 
 
-// Update local copy of DNS zones from current AWS account
-func UpdateLocalZoneStoreFromAWS(minutesAgo int) {
+// Update local copy of DNS zones from given AWS accounts
+func UpdateLocalZoneStoreFromAWS(accounts []*AccountType, minutesAgo int) {
+    // Work out which accounts to update
+    updatedAccounts := GetUpdatedZoneAccountList(accounts, minutesAgo)
+
     // Do full update if minutesAgo is zero (meaning it wasn't specified)
     if minutesAgo == 0 {
         fmt.Printf("Updating local zone store.\n")
     } else {
-        updatedZonesCount := len(GetUpdatedZoneIdList(minutesAgo))
-        if updatedZonesCount < 1 {
+        if len(updatedAccounts) < 1 {
             // Skip zone update if no route53 zone events within last minutesAgo
             fmt.Printf("Skipping local DNS zone store update (no mods within %d minutes)\n",
                 minutesAgo)
             return
         }
-        fmt.Printf("Updating local DNS zone store (%d modified within %d minutes)\n",
-            updatedZonesCount, minutesAgo)
+        fmt.Printf("Updating local DNS zone store (%d accounts modified within %d minutes)\n",
+            len(updatedAccounts), minutesAgo)
+    }
+
+    // Get all records for each account concurrently
+    results := make([][]HostedZoneType, len(updatedAccounts))
+    errs := make([]error, len(updatedAccounts))
+    RunParallel("route53", len(updatedAccounts), func(i int) {
+        results[i], errs[i] = GetZoneListFromAWS(updatedAccounts[i])
+    })
+
+    // Keep previous records for the accounts that failed
+    var updated []*AccountType
+    for i, acct := range updatedAccounts {
+        if UpdateReport.Add(ZoneKind.Name, acct, "", errs[i]) {
+            updated = append(updated, acct)
+        }
+    }
+    if len(updated) == 0 {
+        return   // Nothing to update
     }
 
-    // Create a new list from existing store, without the ones for the current AWS account
+    // Create a new list from existing store, without the ones for the updated accounts
     var list []HostedZoneType
     zoneList, _ := GetZoneList()
     for _, zone := range zoneList {
-        if *zone.AccountId != AWSAccountId {
+        if GetAccountById(updated, zone.AccountId) == nil {
             list = append(list, zone)
         }
     }
 
-    // Now get all records for this account, and add them to this new list
-    for _, zone := range GetZoneListFromAWS() {
-        list = append(list, zone)
+    // Now add all the new records to this new list
+    for _, result := range results {
+        list = append(list, result...)
     }
 
     // Make this the new local list
-    WriteList(list, ZoneDataFile)
+    WriteList(list, ZoneKind)
     return
 }
 
 
-// Return all zone objects in current AWS account
-func GetZoneListFromAWS() (list []HostedZoneType) {
-    SetAWSRegion()
-    sess := session.Must(session.NewSession())
-    svc := route53.New(sess, aws.NewConfig().WithRegion(AWSRegion))
+// Return all zone objects in given AWS account
+func GetZoneListFromAWS(acct *AccountType) (list []HostedZoneType, err error) {
+    svc := route53.New(acct.Sess, AWSConfig(AWSRegion))
 
     params := &route53.ListHostedZonesInput{
         MaxItems: aws.String("100"),  // This is an AWS limit
     }
 
-    // Loop requests in case there're more than PageSize records or we're being throttled
-    errcount := 0
+    // Loop requests in case there're more than PageSize records
     for {
-        // Get batch of records
-        resp, err := svc.ListHostedZones(params)
+        // Get batch of records, retrying as per this service's retry policy
+        var resp *route53.ListHostedZonesOutput
+        err := CallWithRetry("route53", func() (err error) {
+            resp, err = svc.ListHostedZones(params)
+            return err
+        })
         if err != nil {
-            // Sleep for a moment if AWS is throttling us
-            if BeingThrottled(err) {
-                fmt.Printf("  AWS throttling. Sleeping %d seconds...\n", APISecondsDelay)
-                time.Sleep(time.Duration(APISecondsDelay) * time.Second)
-                continue
-            }
-            // Allow for 3 other unknown API call errors before panicking
-            if errcount < 3 {
-                errcount++
-                continue
-            }
-            panic(err.Error())   // Abort on any other error
+            return nil, err
         }
 
         // Ensure valid data came back
@@ -234,19 +268,19 @@ func GetZoneListFromAWS() (list []HostedZoneType) {
             // First convert it to raw []byte
             jsonData, err := json.Marshal(resp.HostedZones)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Now read it into extended type list
             var zoneList []HostedZoneType
             err = json.Unmarshal(jsonData, &zoneList)
             if err != nil {
-                panic(err.Error())
+                return nil, err
             }
             // Add this batch to our list
             for _, zone := range zoneList {
                 // Add our additional fields
-                zone = *zone.SetAccountAlias(AWSAccountAlias)
-                zone = *zone.SetAccountId(AWSAccountId)
+                zone = *zone.SetAccountAlias(acct.Alias)
+                zone = *zone.SetAccountId(acct.Id)
                 list = append(list, zone)
             }
         }
@@ -258,5 +292,5 @@ func GetZoneListFromAWS() (list []HostedZoneType) {
             params.Marker = resp.NextMarker
         }
     }
-    return list
+    return list, nil
 }